@@ -21,7 +21,10 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"mime"
 	"os"
 	"path/filepath"
@@ -31,17 +34,20 @@ import (
 	"math"
 	"os/exec"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	mimeTypeMp3   = "audio/mpeg"
-	mimeTypeWav   = "audio/x-wav"
 	mimeTypeVideo = "video/"
+	mimeTypeAudio = "audio/"
 	app           = "ffmpeg"
+	probeApp      = "ffprobe"
 	wavOption     = []string{"-ac", "1", "-ar", "44100"}
 	mp3Option     = []string{"-ac", "1", "-ar", "44100", "-b:a", "32k"}
+	mp4Option     = []string{"-f", "mp4", "-vcodec", "libx264", "-preset", "veryfast", "-profile:v", "main", "-movflags", "+faststart"}
 )
 
 // getFileExtension returns file extension from file name
@@ -81,7 +87,8 @@ func isFileVideo(file string) bool {
 	return true
 }
 
-// isFileAudio checks if file is mp3 or wav using mime type
+// isFileAudio checks if file is audio, such as mp3, wav, m4a or flac, using
+// mime type
 func isFileAudio(file string) bool {
 	fi, err := os.Stat(file)
 	if err != nil {
@@ -98,7 +105,7 @@ func isFileAudio(file string) bool {
 
 	fileType := mime.TypeByExtension(getFileExtension(file))
 
-	if fileType != mimeTypeMp3 && fileType != mimeTypeWav {
+	if !strings.HasPrefix(fileType, mimeTypeAudio) {
 		if v, _ := rootCmd.Flags().GetBool("verbose"); v {
 			fmt.Printf("%v \t %v\n", fi.Name(), fileType)
 		}
@@ -108,6 +115,136 @@ func isFileAudio(file string) bool {
 	return true
 }
 
+// copyFile copies file src to dst
+func copyFile(src string, dst string) error {
+	return copyFileFlag(src, dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+// copyFileFlag copies file src to dst, which is opened with flag
+func copyFileFlag(src string, dst string, flag int) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, flag, 0666)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// moveFile moves file src to dst. It fails if dst exists, so a file is
+// never replaced. If they are on different devices, src is copied to dst,
+// compared with it and then removed.
+func moveFile(src string, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: os.ErrExist}
+	}
+
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	// O_EXCL fails if dst is created since it was checked
+	if err := copyFileFlag(src, dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			os.Remove(dst)
+		}
+		return err
+	}
+
+	if err := verifyCopy(src, dst, fi); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// verifyCopy gives copy dst of src mode and modification time fi of src,
+// as rename keeps them, and checks that content of dst is the same.
+func verifyCopy(src string, dst string, fi os.FileInfo) error {
+	if err := os.Chmod(dst, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(dst, fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+
+	same, err := sameFileContent(src, dst)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return fmt.Errorf("copy of %s to %s is not the same", src, dst)
+	}
+	return nil
+}
+
+// freeFileName returns file, or file with a suffix like " (1)" before its
+// extension if file exists
+func freeFileName(file string) string {
+	ext := filepath.Ext(file)
+	base := strings.TrimSuffix(file, ext)
+
+	n := file
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(n); os.IsNotExist(err) {
+			return n
+		}
+		n = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// sameFileContent checks if files a and b have the same content
+func sameFileContent(a string, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA, bufB := make([]byte, 64*1024), make([]byte, 64*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return false, errA
+		}
+		if errB != nil && !endB {
+			return false, errB
+		}
+		if endA || endB {
+			return endA && endB, nil
+		}
+	}
+}
+
 func createDirectory(path string) error {
 	return os.MkdirAll(path, os.ModePerm)
 }
@@ -120,49 +257,211 @@ func createOutputDirectory(cmd *cobra.Command) string {
 	return o
 }
 
-func getLength(file string) (int, error) {
-
-	out, err := exec.Command("sh", "-c", "ffmpeg -i "+file+
-		" 2>&1 | grep Duration | cut -d ' ' -f 4 | sed s/,//").Output()
+// getDuration returns duration of the file with sub-second precision
+func getDuration(file string) (time.Duration, error) {
+	out, err := exec.Command(probeApp, "-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", file).Output()
 	if err != nil {
 		return 0, err
 	}
 
-	outString := string(out[:])
-	if len(outString) == 0 {
+	outString := strings.TrimSpace(string(out[:]))
+	if len(outString) == 0 || outString == "N/A" {
 		fmt.Fprint(os.Stderr, "Duration is empty")
 		return 0, errors.New("failed to read duration")
 	}
 
-	split := strings.Split(outString, ":")
+	seconds, err := strconv.ParseFloat(outString, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return secondsToDuration(seconds), nil
+}
+
+// parseDuration parses duration given as seconds (90, 90.5), Go duration
+// (1m30.5s) or clock time (00:01:30.500, 01:30)
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return secondsToDuration(f), nil
+	}
+
+	if strings.Contains(s, ":") {
+		parts := strings.Split(s, ":")
+		if len(parts) > 3 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
 
-	var seconds int
+		var seconds float64
+		for _, p := range parts {
+			f, err := strconv.ParseFloat(p, 64)
+			if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			seconds = seconds*60 + f
+		}
+		return secondsToDuration(seconds), nil
+	}
 
-	hour := strings.Replace(split[0], "\n", "", -1)
-	hourFloat, err := strconv.ParseFloat(hour, 64)
+	d, err := time.ParseDuration(s)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	return d, nil
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Round(seconds * float64(time.Second)))
+}
+
+// formatSeconds formats duration as seconds for ffmpeg options and filters
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// runFFmpeg runs ffmpeg with arguments a and prints its output
+func runFFmpeg(a []string) error {
+	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+		fmt.Printf("Command is\n%s %v\n", app, a)
 	}
 
-	hourInt := int(math.Floor(hourFloat))
+	cmd := exec.Command(app, a...)
+
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	return cmd.Run()
+}
+
+// writeConcatList writes a list of files for the concat demuxer to a new
+// file in dir and returns its path
+func writeConcatList(dir string, files []string) (string, error) {
+	var b strings.Builder
+	for _, f := range files {
+		p, err := filepath.Abs(f)
+		if err != nil {
+			return "", err
+		}
+		// Quote is closed, escaped and opened again
+		b.WriteString(fmt.Sprintf("file '%s'\n", strings.Replace(p, "'", `'\''`, -1)))
+	}
 
-	minute := strings.Replace(split[1], "\n", "", -1)
-	minuteFloat, err := strconv.ParseFloat(minute, 64)
+	list, err := ioutil.TempFile(dir, "concat")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := list.WriteString(b.String()); err != nil {
+		list.Close()
+		os.Remove(list.Name())
+		return "", err
+	}
+	if err := list.Close(); err != nil {
+		os.Remove(list.Name())
+		return "", err
+	}
+	return list.Name(), nil
+}
+
+// getFrameRate returns frame rate of the first video stream of file
+func getFrameRate(file string) (float64, error) {
+	out, err := exec.Command(probeApp, "-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=r_frame_rate",
+		"-of", "default=noprint_wrappers=1:nokey=1", file).Output()
 	if err != nil {
 		return 0, err
 	}
 
-	minuteInt := int(math.Floor(minuteFloat))
+	return parseFrameRate(strings.TrimSpace(string(out)))
+}
 
-	second := strings.Replace(split[2], "\n", "", -1)
-	secondFloat, err := strconv.ParseFloat(second, 64)
+// parseFrameRate parses frame rate like 25 or 30000/1001
+func parseFrameRate(s string) (float64, error) {
+	n, d := s, "1"
+	if i := strings.Index(s, "/"); i >= 0 {
+		n, d = s[:i], s[i+1:]
+	}
+
+	num, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, err
+	}
+	den, err := strconv.ParseFloat(d, 64)
 	if err != nil {
 		return 0, err
 	}
+	if num <= 0 || den <= 0 {
+		return 0, fmt.Errorf("invalid frame rate %q", s)
+	}
 
-	secondInt := int(math.Floor(secondFloat))
+	return num / den, nil
+}
 
-	seconds = (hourInt * 3600) + (minuteInt * 60) + (secondInt)
+// hasAudioStream checks if file has at least one audio stream
+func hasAudioStream(file string) bool {
+	out, err := exec.Command(probeApp, "-v", "error",
+		"-select_streams", "a",
+		"-show_entries", "stream=index",
+		"-of", "csv=p=0", file).Output()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	return len(strings.TrimSpace(string(out))) > 0
+}
+
+var ffmpegLists = make(map[string]map[string]bool)
+
+// ffmpegList returns names that ffmpeg lists with flag like -filters,
+// -encoders or -pix_fmts. Every list is read once.
+func ffmpegList(flag string) map[string]bool {
+	if l, ok := ffmpegLists[flag]; ok {
+		return l
+	}
+
+	l := make(map[string]bool)
+	ffmpegLists[flag] = l
+
+	out, err := exec.Command(app, "-hide_banner", flag).Output()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return l
+	}
+
+	// Each line is like " TSC xfade  VV->V  Cross fade ...",
+	// " V....D libx264  libx264 H.264 ..." or "IO... yuv420p  3  12  8-8-8"
+	for _, e := range strings.Split(string(out), "\n") {
+		f := strings.Fields(e)
+		if len(f) > 2 {
+			l[f[1]] = true
+		}
+	}
+
+	return l
+}
+
+// hasFilter checks if local ffmpeg has the filter
+func hasFilter(name string) bool {
+	return ffmpegList("-filters")[name]
+}
+
+// hasEncoder checks if local ffmpeg has the encoder
+func hasEncoder(name string) bool {
+	return ffmpegList("-encoders")[name]
+}
 
-	return seconds, nil
+// hasPixelFormat checks if local ffmpeg has the pixel format
+func hasPixelFormat(name string) bool {
+	return ffmpegList("-pix_fmts")[name]
 }
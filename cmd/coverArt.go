@@ -0,0 +1,158 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// coverArtCmd represents the coverArt command
+var coverArtCmd = &cobra.Command{
+	Use:   "coverArt",
+	Short: "Embed an image as cover art of video or audio files",
+	Long: `Embeds image given with -i as cover art of the files. Streams of the files are
+copied without encoding and cover art that is already in a file is replaced.
+
+Image must be png or jpg. Files can be mp4, m4a, m4v, mov, mp3 or flac. In mp3
+image is written as APIC frame of ID3v2.
+
+A file is written next to the original and then renamed over it, so the
+original is not lost if ffmpeg fails.
+
+Usage:
+$ bmtool coverArt -i cover.jpg song.mp3 clip.mp4
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		image, _ := cmd.Flags().GetString("image")
+		if err := checkCoverArtImage(image); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		for _, e := range args {
+			embedImage(e, image)
+		}
+	},
+}
+
+// coverArtFormats are extensions of files that can hold cover art
+var coverArtFormats = []string{".mp4", ".m4a", ".m4v", ".mov", ".mp3", ".flac"}
+
+func init() {
+	rootCmd.AddCommand(coverArtCmd)
+	coverArtCmd.Flags().StringP("image", "i", "", "Cover art image. png|jpg")
+	coverArtCmd.MarkFlagRequired("image")
+}
+
+// embedImage embeds image in file as its cover art
+func embedImage(file string, image string) {
+	if err := embedCoverArt(file, image); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to embed cover art in %s\t%s\n", file, err)
+		return
+	}
+
+	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+		fmt.Printf("Embed %s in %s\n", image, file)
+	}
+}
+
+// checkCoverArtImage checks if image can be used as cover art
+func checkCoverArtImage(image string) error {
+	if _, err := os.Stat(image); err != nil {
+		return err
+	}
+
+	if ext := getFileExtension(image); ext != ".png" && ext != ".jpg" && ext != ".jpeg" {
+		return fmt.Errorf("cover art must be png or jpg, not %s", ext)
+	}
+	return nil
+}
+
+// embedCoverArt muxes image into file as cover art without encoding and
+// replaces file with the result
+func embedCoverArt(file string, image string) error {
+	ext := getFileExtension(file)
+	if indexOf(coverArtFormats, ext) < 0 {
+		return fmt.Errorf("%s can not hold cover art, valid formats are [%s]", ext, strings.Join(coverArtFormats, "|"))
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	info, err := probeMedia(file)
+	if err != nil {
+		return err
+	}
+
+	// Keep every stream except the old cover art
+	a := []string{"-hide_banner", "-v", "error", "-i", file, "-i", image}
+	videos := 0
+	for _, s := range info.Streams {
+		if s.Disposition["attached_pic"] != 0 {
+			continue
+		}
+		a = append(a, "-map", fmt.Sprintf("0:%d", s.Index))
+		if s.CodecType == "video" {
+			videos++
+		}
+	}
+	a = append(a, "-map", "1", "-map_metadata", "0", "-c", "copy",
+		fmt.Sprintf("-disposition:v:%d", videos), "attached_pic")
+
+	if ext == ".mp3" {
+		a = append(a, "-id3v2_version", "3",
+			"-metadata:s:v", "title=Album cover",
+			"-metadata:s:v", "comment=Cover (front)")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+getFileNameWithoutExtension(file)+"-*"+ext)
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+
+	a = append(a, "-y", tmp.Name())
+	if err := runFFmpeg(a); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), fi.Mode()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// Rename is atomic, file is either the original or the new one
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// videoEncoding is container, video encoder and format of an output
+type videoEncoding struct {
+	container  string
+	vcodec     string
+	crf        int // negative for default of the encoder
+	preset     string
+	maxBitrate string
+	pixFmt     string
+	// custom is true if any encoder option is set by the user
+	custom bool
+	format videoFormat
+}
+
+var (
+	containerFormats = map[string]string{"mp4": "mp4", "mkv": "matroska", "webm": "webm", "mov": "mov"}
+	containerNames   = []string{"mp4", "mkv", "webm", "mov"}
+	videoEncoders    = []string{"libx264", "libx265", "libvpx-vp9", "libaom-av1", "prores"}
+	// containerEncoders are video encoders a container can hold
+	containerEncoders = map[string][]string{
+		"mp4":  {"libx264", "libx265", "libvpx-vp9", "libaom-av1"},
+		"mkv":  videoEncoders,
+		"webm": {"libvpx-vp9", "libaom-av1"},
+		"mov":  {"libx264", "libx265", "prores"},
+	}
+	encoderPresets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast",
+		"medium", "slow", "slower", "veryslow"}
+	proresProfiles = []string{"proxy", "lt", "standard", "hq", "4444", "4444xq"}
+	// maxCRF is the highest crf value of an encoder
+	maxCRF = map[string]int{"libx264": 51, "libx265": 51, "libvpx-vp9": 63, "libaom-av1": 63}
+	// containerDefaultEncoders are used when --vcodec is not set
+	containerDefaultEncoders = map[string]string{"mp4": "libx264", "mkv": "libx264", "webm": "libvpx-vp9", "mov": "libx264"}
+)
+
+// addVideoEncodingFlags adds flags read by newVideoEncoding to cmd
+func addVideoEncodingFlags(cmd *cobra.Command) {
+	cmd.Flags().String("container", "mp4", "Output container. "+strings.Join(containerNames, "|"))
+	cmd.Flags().String("vcodec", "", "Video encoder. "+strings.Join(videoEncoders, "|")+". Default is libx264, or libvpx-vp9 for webm")
+	cmd.Flags().Int("crf", -1, "Constant rate factor. Lower is better. Default is the encoder default")
+	cmd.Flags().String("preset", "veryfast", "Encoder preset. "+strings.Join(encoderPresets, "|")+". For prores "+strings.Join(proresProfiles, "|"))
+	cmd.Flags().String("max-bitrate", "", "Highest video bit rate e.g. 5M. Default no limit")
+	cmd.Flags().String("pix-fmt", "", "Pixel format e.g. yuv420p. Default is chosen by the encoder")
+}
+
+// newVideoEncoding reads encoding flags of cmd and checks them against
+// encoders of local ffmpeg
+func newVideoEncoding(cmd *cobra.Command) (videoEncoding, error) {
+	var v videoEncoding
+
+	v.container, _ = cmd.Flags().GetString("container")
+	v.vcodec, _ = cmd.Flags().GetString("vcodec")
+	v.crf, _ = cmd.Flags().GetInt("crf")
+	v.preset, _ = cmd.Flags().GetString("preset")
+	v.maxBitrate, _ = cmd.Flags().GetString("max-bitrate")
+	v.pixFmt, _ = cmd.Flags().GetString("pix-fmt")
+
+	for _, f := range []string{"vcodec", "crf", "preset", "max-bitrate", "pix-fmt"} {
+		if cmd.Flags().Changed(f) {
+			v.custom = true
+		}
+	}
+
+	if _, ok := containerFormats[v.container]; !ok {
+		return v, fmt.Errorf("unknown container %v, valid values are [%s]", v.container, strings.Join(containerNames, "|"))
+	}
+
+	if !cmd.Flags().Changed("vcodec") {
+		v.vcodec = containerDefaultEncoders[v.container]
+	}
+
+	if indexOf(videoEncoders, v.vcodec) < 0 {
+		return v, fmt.Errorf("unknown video encoder %v, valid values are [%s]", v.vcodec, strings.Join(videoEncoders, "|"))
+	}
+
+	if indexOf(containerEncoders[v.container], v.vcodec) < 0 {
+		return v, fmt.Errorf("%s can not hold %s, valid encoders are [%s]", v.container, v.vcodec,
+			strings.Join(containerEncoders[v.container], "|"))
+	}
+
+	if !hasEncoder(v.vcodec) {
+		return v, fmt.Errorf("ffmpeg has no %s encoder", v.vcodec)
+	}
+
+	if !hasEncoder(v.audioCodec()) {
+		return v, fmt.Errorf("ffmpeg has no %s encoder", v.audioCodec())
+	}
+
+	if v.pixFmt != "" && !hasPixelFormat(v.pixFmt) {
+		return v, fmt.Errorf("ffmpeg has no %s pixel format", v.pixFmt)
+	}
+
+	if v.maxBitrate != "" {
+		if _, err := parseBitrate(v.maxBitrate); err != nil {
+			return v, err
+		}
+	}
+
+	if v.crf < -1 {
+		return v, fmt.Errorf("invalid crf %d, use -1 for the encoder default", v.crf)
+	}
+
+	if v.vcodec == "prores" {
+		if !cmd.Flags().Changed("preset") {
+			v.preset = "standard"
+		}
+		if indexOf(proresProfiles, v.preset) < 0 {
+			return v, fmt.Errorf("unknown prores profile %v, valid values are [%s]", v.preset, strings.Join(proresProfiles, "|"))
+		}
+		if v.crf >= 0 {
+			return v, fmt.Errorf("prores has no crf, use --preset to pick a profile")
+		}
+	} else {
+		if indexOf(encoderPresets, v.preset) < 0 {
+			return v, fmt.Errorf("unknown preset %v, valid values are [%s]", v.preset, strings.Join(encoderPresets, "|"))
+		}
+		if v.crf > maxCRF[v.vcodec] {
+			return v, fmt.Errorf("crf of %s must be from 0 to %d", v.vcodec, maxCRF[v.vcodec])
+		}
+	}
+
+	return v, nil
+}
+
+// parseBitrate parses bit rate in bits per second like 800k, 5M or 2500000
+func parseBitrate(s string) (int64, error) {
+	multiplier := 1.0
+	n := s
+	if l := len(s); l > 0 {
+		switch s[l-1] {
+		case 'k', 'K':
+			multiplier, n = 1e3, s[:l-1]
+		case 'm', 'M':
+			multiplier, n = 1e6, s[:l-1]
+		case 'g', 'G':
+			multiplier, n = 1e9, s[:l-1]
+		}
+	}
+
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil || !(f*multiplier >= 1) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid bit rate %q, use e.g. 800k or 5M", s)
+	}
+
+	return int64(f * multiplier), nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, e := range list {
+		if e == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// extension returns file extension of the output without dot
+func (v videoEncoding) extension() string {
+	return v.container
+}
+
+// audioCodec returns audio encoder that fits the container
+func (v videoEncoding) audioCodec() string {
+	if v.container == "webm" {
+		return "libopus"
+	}
+	return "aac"
+}
+
+// muxOption returns options of the container
+func (v videoEncoding) muxOption() []string {
+	a := []string{"-f", containerFormats[v.container]}
+	if v.container == "mp4" || v.container == "mov" {
+		a = append(a, "-movflags", "+faststart")
+	}
+	return a
+}
+
+// cpuUsed maps preset to -cpu-used of libvpx and libaom, where 0 is the
+// slowest and fastest is the quickest.
+func (v videoEncoding) cpuUsed(fastest int) string {
+	i := indexOf(encoderPresets, v.preset)
+	return strconv.Itoa(fastest - i*fastest/(len(encoderPresets)-1))
+}
+
+// args returns ffmpeg options that encode the output
+func (v videoEncoding) args() []string {
+	a := v.muxOption()
+	a = append(a, "-c:v", v.vcodec)
+
+	constrained := false
+	switch v.vcodec {
+	case "libx264", "libx265":
+		a = append(a, "-preset", v.preset)
+		if v.crf >= 0 {
+			a = append(a, "-crf", strconv.Itoa(v.crf))
+		}
+		if v.vcodec == "libx264" && (v.pixFmt == "" || v.pixFmt == "yuv420p") {
+			a = append(a, "-profile:v", "main")
+		}
+		if v.vcodec == "libx265" && v.container != "mkv" {
+			a = append(a, "-tag:v", "hvc1")
+		}
+	case "libvpx-vp9", "libaom-av1":
+		if v.vcodec == "libvpx-vp9" {
+			a = append(a, "-deadline", "good", "-cpu-used", v.cpuUsed(5), "-row-mt", "1")
+		} else {
+			a = append(a, "-cpu-used", v.cpuUsed(8))
+		}
+		if v.crf >= 0 {
+			// With -b:v crf is the quality and -b:v its limit, without it
+			// -b:v must be 0
+			a = append(a, "-crf", strconv.Itoa(v.crf))
+			constrained = true
+			if v.maxBitrate == "" {
+				a = append(a, "-b:v", "0")
+			}
+		}
+	case "prores":
+		a = append(a, "-profile:v", strconv.Itoa(indexOf(proresProfiles, v.preset)))
+	}
+
+	if v.maxBitrate != "" && constrained {
+		a = append(a, "-b:v", v.maxBitrate)
+	} else if v.maxBitrate != "" {
+		a = append(a, "-maxrate", v.maxBitrate, "-bufsize", v.maxBitrate)
+	}
+
+	if v.pixFmt != "" {
+		a = append(a, "-pix_fmt", v.pixFmt)
+	}
+
+	return append(a, "-c:a", v.audioCodec())
+}
+
+// copyArgs returns ffmpeg options that copy video to the output. Audio is
+// copied if copyAudio is true, else it is encoded.
+func (v videoEncoding) copyArgs(copyAudio bool) []string {
+	a := v.muxOption()
+	a = append(a, "-c:v", "copy")
+	if copyAudio {
+		return append(a, "-c:a", "copy")
+	}
+	return append(a, "-c:a", v.audioCodec())
+}
+
+var (
+	// copyVideoCodecs and copyAudioCodecs are codecs that are copied to a
+	// container without encoding. mkv holds any codec.
+	copyVideoCodecs = map[string][]string{
+		"mp4":  {"h264", "hevc", "mpeg4", "av1", "vp9"},
+		"mov":  {"h264", "hevc", "mpeg4", "prores"},
+		"webm": {"vp8", "vp9", "av1"},
+	}
+	copyAudioCodecs = map[string][]string{
+		"mp4":  {"aac", "mp3", "ac3", "alac", "opus"},
+		"mov":  {"aac", "mp3", "ac3", "alac", "pcm_s16le"},
+		"webm": {"opus", "vorbis"},
+	}
+)
+
+// canHold checks if the container holds codec of kind video or audio
+// without encoding
+func (v videoEncoding) canHold(kind string, codec string) bool {
+	list := copyVideoCodecs
+	if kind == "audio" {
+		list = copyAudioCodecs
+	}
+
+	codecs, ok := list[v.container]
+	return !ok || indexOf(codecs, codec) >= 0
+}
@@ -21,9 +21,13 @@
 package cmd
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -35,20 +39,148 @@ var fileRenameCmd = &cobra.Command{
 	Short: "Rename file to its ModTime",
 	Long: `Rename file to the modification time of file.
 
+--time-source picks where time of file is taken from.
+  exif      DateTimeOriginal of jpeg, heic and tiff photos
+  media     creation_time tag of video and audio, such as mp4, mov and mp3
+  mtime     modification time of file. It is the default.
+  ctime     status change time of file. On Windows it is the creation time.
+  filename  date and time in name of file e.g. IMG_20190503_102030.jpg
+  auto      the first of exif, media, filename and mtime that file has
+
+Name is written in the zone given with --tz, an IANA name like Asia/Karachi or
+local. Default is local. Times of media are UTC and are converted to the zone.
+EXIF time is taken from OffsetTimeOriginal if photo has it. Otherwise EXIF time
+and time in file name have no zone, and are taken as times in --tz.
+
+--shift adds a duration to time e.g. +1h30m or -45m, to correct a camera with
+the wrong clock.
+
+--organize moves files to a directory under --root, which is the current
+directory by default. Directory is a Go template of the time of file, such as
+"{{.Time.Year}}/{{.Time.Format \"01-January\"}}". Fields are Time, Name, the
+new name of file, and Ext. Directories are created if needed. Files on another
+device are copied, compared and then removed.
+
+A file is never replaced. If the new name is taken, a suffix like " (1)" is added
+to it.
+
+Every run writes the renames to a journal in bmtool/fileRename directory of
+$XDG_STATE_HOME, or ~/.local/state, or --state-dir. --undo renames files of a run
+back, latest first. It takes the run ID printed by the run, or last. Files whose
+old name is taken or that are missing are reported and left as they are.
+
 Usage:
 
 $ bmtool fileRename example.mp3 
 This will rename "example.mp3" to "2016-11-04 130738.mp3"
+
+$ bmtool fileRename --time-source auto IMG_1234.jpg
+
+$ bmtool fileRename --undo last
+
+$ bmtool fileRename --organize "{{.Time.Year}}/{{.Time.Format \"01-January\"}}" --root ~/Photos *.jpg
+This will move "IMG_1234.jpg" to "~/Photos/2016/11-November/2016-11-04 130738.jpg"
 `,
-	Args: cobra.MinimumNArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		if u, _ := cmd.Flags().GetString("undo"); u != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		stateDir, _ := cmd.Flags().GetString("state-dir")
+		dir, err := getJournalDir(stateDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		if u, _ := cmd.Flags().GetString("undo"); u != "" {
+			if err := undoRename(dir, u); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return
+		}
+
+		source, _ := cmd.Flags().GetString("time-source")
+		if indexOf(timeSources, source) < 0 {
+			fmt.Fprintf(os.Stderr, "Unknown time source %v. Valid values are [%s]\n", source, strings.Join(timeSources, "|"))
+			return
+		}
+
+		tz, _ := cmd.Flags().GetString("tz")
+		loc, err := loadLocation(tz)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unknown time zone %v\t%s\n", tz, err)
+			return
+		}
+
+		s, _ := cmd.Flags().GetString("shift")
+		var shift time.Duration
+		if s != "" {
+			shift, err = time.ParseDuration(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid shift %v. Use a duration e.g. +1h30m\n", s)
+				return
+			}
+		}
+
+		var organize *template.Template
+		if o, _ := cmd.Flags().GetString("organize"); o != "" {
+			organize, err = template.New("organize").Option("missingkey=error").Parse(o)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid organize template %v\t%s\n", o, err)
+				return
+			}
+		}
+		root, _ := cmd.Flags().GetString("root")
+
+		journal := newRenameJournal(dir)
+		defer journal.close()
+
 		for _, e := range args {
 			fi, err := getFileInfo(e)
 			if err == nil {
+				t, used, err := getFileTime(e, fi, source, loc)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to get %s time of %s\t%s\n", source, e, err)
+					continue
+				}
+				t = t.Add(shift).In(loc)
+
+				if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+					fmt.Printf("Time of %v is %v from %v\n", e, t, used)
+				}
 
 				n :=
-					getNewName(fi.ModTime(), getFileExtension(e))
-				rename(e, n)
+					getNewName(t, getFileExtension(e))
+				if organize != nil {
+					d, err := getOrganizeDirectory(organize, root, t, n)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Unable to organize %v\t%s\n", e, err)
+						continue
+					}
+					if err := createDirectory(d); err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						continue
+					}
+					n = filepath.Join(d, n)
+				}
+
+				if nfi, err := os.Stat(n); err == nil && os.SameFile(fi, nfi) {
+					if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+						fmt.Printf("%v is already named %v\n", e, n)
+					}
+					continue
+				}
+				// Files taken in the same second get the same name
+				n = freeFileName(n)
+
+				if rename(e, n) == nil {
+					if err := journal.record(e, n); err != nil {
+						fmt.Fprintf(os.Stderr, "Unable to write journal\t%s\n", err)
+					}
+				}
 			}
 
 		}
@@ -57,16 +189,45 @@ This will rename "example.mp3" to "2016-11-04 130738.mp3"
 
 func init() {
 	rootCmd.AddCommand(fileRenameCmd)
+	fileRenameCmd.Flags().String("time-source", timeSourceMtime, "Source of time. "+strings.Join(timeSources, "|"))
+	fileRenameCmd.Flags().String("tz", "local", "Time zone of name. IANA name e.g. Europe/Berlin or local")
+	fileRenameCmd.Flags().String("shift", "", "Add duration to time e.g. +1h30m")
+	fileRenameCmd.Flags().String("undo", "", "Undo a run. Run ID or last")
+	fileRenameCmd.Flags().String("organize", "", "Move files to directory given by template e.g. {{.Time.Year}}")
+	fileRenameCmd.Flags().String("root", ".", "Root directory of --organize")
+	fileRenameCmd.Flags().String("state-dir", "", "Directory of journals. Default is $XDG_STATE_HOME or ~/.local/state")
 }
 
-func rename(file string, newName string) {
-	err := os.Rename(file, newName)
+func rename(file string, newName string) error {
+	err := moveFile(file, newName)
 	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
 		fmt.Printf("Rename %v to %v\n", file, newName)
 	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 	}
+	return err
+}
+
+// getOrganizeDirectory returns directory of file with time t and new name n
+// under root, as given by template tmpl
+func getOrganizeDirectory(tmpl *template.Template, root string, t time.Time, n string) (string, error) {
+	var b bytes.Buffer
+	err := tmpl.Execute(&b, struct {
+		Time time.Time
+		Name string
+		Ext  string
+	}{t, n, filepath.Ext(n)})
+	if err != nil {
+		return "", err
+	}
+
+	d := filepath.Clean(filepath.FromSlash(strings.TrimSpace(b.String())))
+	if filepath.IsAbs(d) || d == ".." || strings.HasPrefix(d, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("directory %q is not under root", b.String())
+	}
+
+	return filepath.Join(root, d), nil
 }
 
 func getNewName(t time.Time, ext string) string {
@@ -0,0 +1,241 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+const (
+	journalExt = ".jsonl"
+	// undoneExt is appended to journal of a run that is undone
+	undoneExt = ".undone"
+)
+
+// journalEntry is a rename done by fileRename. Paths are absolute.
+type journalEntry struct {
+	Old  string    `json:"old"`
+	New  string    `json:"new"`
+	Time time.Time `json:"time"`
+	Run  string    `json:"run"`
+}
+
+// renameJournal writes renames of a run as JSON lines. File is created on
+// the first rename, so runs that rename nothing leave no journal.
+type renameJournal struct {
+	dir  string
+	run  string
+	file *os.File
+}
+
+// getJournalDir returns directory of fileRename journals. Default is
+// bmtool/fileRename in $XDG_STATE_HOME, or ~/.local/state.
+func getJournalDir(stateDir string) (string, error) {
+	if stateDir == "" {
+		stateDir = os.Getenv("XDG_STATE_HOME")
+	}
+	if stateDir == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return "", err
+		}
+		stateDir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateDir, "bmtool", "fileRename"), nil
+}
+
+// newRenameJournal returns journal of a new run in dir. Run ID is the time
+// of the run, so IDs sort by time.
+func newRenameJournal(dir string) *renameJournal {
+	return &renameJournal{dir: dir, run: time.Now().UTC().Format("20060102-150405.000000")}
+}
+
+// record adds rename of old to new to the journal
+func (j *renameJournal) record(old string, new string) error {
+	if j.file == nil {
+		if err := os.MkdirAll(j.dir, 0755); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(filepath.Join(j.dir, j.run+journalExt), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		j.file = f
+	}
+
+	e := journalEntry{Time: time.Now(), Run: j.run}
+	var err error
+	if e.Old, err = filepath.Abs(old); err != nil {
+		return err
+	}
+	if e.New, err = filepath.Abs(new); err != nil {
+		return err
+	}
+
+	// Every entry is written at once, so a crash loses at most one line
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = j.file.Write(append(b, '\n'))
+	return err
+}
+
+// close closes journal file. It prints run ID if anything is renamed.
+func (j *renameJournal) close() {
+	if j.file == nil {
+		return
+	}
+
+	if err := j.file.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	fmt.Printf("Run %s. Undo with --undo %s\n", j.run, j.run)
+}
+
+// findJournal returns path of journal of run in dir. Run last is the
+// latest run that is not undone.
+func findJournal(dir string, run string) (string, error) {
+	if run != "last" {
+		p := filepath.Join(dir, run+journalExt)
+		if _, err := os.Stat(p); err != nil {
+			if _, errUndone := os.Stat(p + undoneExt); errUndone == nil {
+				return "", fmt.Errorf("run %s is already undone", run)
+			}
+			return "", fmt.Errorf("no journal of run %s", run)
+		}
+		return p, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+journalExt))
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", errors.New("no run to undo")
+	}
+
+	sort.Strings(files)
+	return files[len(files)-1], nil
+}
+
+// readJournal returns entries of journal file
+func readJournal(file string) ([]journalEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" {
+			continue
+		}
+
+		var e journalEntry
+		if err := json.Unmarshal([]byte(l), &e); err != nil {
+			return nil, fmt.Errorf("invalid journal %s\t%s", file, err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// undoRename renames files of run back, latest first. A file is not
+// restored if it is missing or its old name is taken. Entries that are not
+// restored are kept in the journal, so undo can be run again once they are
+// fixed. When all of them are restored, journal is marked undone.
+func undoRename(dir string, run string) error {
+	file, err := findJournal(dir, run)
+	if err != nil {
+		return err
+	}
+
+	entries, err := readJournal(file)
+	if err != nil {
+		return err
+	}
+
+	var failed []journalEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+
+		if _, err := os.Stat(e.New); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to restore %v\t%v is missing\n", e.Old, e.New)
+			failed = append(failed, e)
+			continue
+		}
+
+		if _, err := os.Stat(e.Old); err == nil {
+			fmt.Fprintf(os.Stderr, "Unable to restore %v\tit already exists\n", e.Old)
+			failed = append(failed, e)
+			continue
+		}
+
+		if err := moveFile(e.New, e.Old); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to restore %v\t%s\n", e.Old, err)
+			failed = append(failed, e)
+			continue
+		}
+
+		if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+			fmt.Printf("Rename %v to %v\n", e.New, e.Old)
+		}
+	}
+
+	fmt.Printf("Restored %d of %d files of run %s\n", len(entries)-len(failed), len(entries),
+		strings.TrimSuffix(filepath.Base(file), journalExt))
+
+	if len(failed) == 0 {
+		return os.Rename(file, file+undoneExt)
+	}
+
+	// Keep failed entries in the order they were renamed
+	var b strings.Builder
+	for i := len(failed) - 1; i >= 0; i-- {
+		l, err := json.Marshal(failed[i])
+		if err != nil {
+			return err
+		}
+		b.Write(append(l, '\n'))
+	}
+	if err := ioutil.WriteFile(file, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%d files are not restored", len(failed))
+}
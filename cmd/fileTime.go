@@ -0,0 +1,190 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/talha131/bmtool/exif"
+)
+
+// Sources of time of a file
+const (
+	timeSourceExif     = "exif"
+	timeSourceMedia    = "media"
+	timeSourceMtime    = "mtime"
+	timeSourceCtime    = "ctime"
+	timeSourceFilename = "filename"
+	timeSourceAuto     = "auto"
+)
+
+var (
+	timeSources = []string{timeSourceExif, timeSourceMedia, timeSourceMtime, timeSourceCtime,
+		timeSourceFilename, timeSourceAuto}
+	// autoTimeSources are tried in order by auto. mtime is always there.
+	autoTimeSources = []string{timeSourceExif, timeSourceMedia, timeSourceFilename, timeSourceMtime}
+
+	// mediaTimeTags are tags of creation time, in order of preference
+	mediaTimeTags = []string{"com.apple.quicktime.creationdate", "creation_time", "date"}
+	// mediaTimeLayouts are layouts of creation time tags
+	mediaTimeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05-0700",
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02",
+	}
+
+	// filenameTimePattern matches date and optional time in names like
+	// IMG_20190503_102030, 2019-05-03 102030, PXL_20190503_102030123 and
+	// VID-20190503-WA0001
+	filenameTimePattern = regexp.MustCompile(`(?:^|\D)((?:19|20)\d\d)[-_.]?(\d\d)[-_.]?(\d\d)(?:[ T_.-]?(\d\d)[-_.:]?(\d\d)[-_.:]?(\d\d)|\D|$)`)
+)
+
+// getFileTime returns time of file from source and the source it is taken
+// from. auto takes the first of autoTimeSources that file has. Times that
+// have no zone, like EXIF without offset and dates in file names, are taken
+// as times in loc.
+func getFileTime(file string, fi os.FileInfo, source string, loc *time.Location) (time.Time, string, error) {
+	if source != timeSourceAuto {
+		t, err := getFileTimeFrom(file, fi, source, loc)
+		return t, source, err
+	}
+
+	for _, s := range autoTimeSources {
+		if t, err := getFileTimeFrom(file, fi, s, loc); err == nil {
+			return t, s, nil
+		}
+	}
+	return time.Time{}, "", errors.New("no time found")
+}
+
+func getFileTimeFrom(file string, fi os.FileInfo, source string, loc *time.Location) (time.Time, error) {
+	switch source {
+	case timeSourceExif:
+		e, err := exif.ReadFile(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return e.Time(loc)
+	case timeSourceMedia:
+		return getMediaTime(file)
+	case timeSourceMtime:
+		return fi.ModTime(), nil
+	case timeSourceCtime:
+		return getChangeTime(fi)
+	case timeSourceFilename:
+		return getFilenameTime(file, loc)
+	}
+	return time.Time{}, fmt.Errorf("unknown time source %v", source)
+}
+
+// getMediaTime returns creation time of a video or audio file from tags of
+// its format or streams
+func getMediaTime(file string) (time.Time, error) {
+	if !isMediaFile(file) {
+		return time.Time{}, errors.New("not a media file")
+	}
+
+	info, err := probeMedia(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	tags := []map[string]string{info.Format.Tags}
+	for _, s := range info.Streams {
+		tags = append(tags, s.Tags)
+	}
+
+	for _, name := range mediaTimeTags {
+		for _, tag := range tags {
+			if v, ok := tag[name]; ok {
+				if t, err := parseMediaTime(v); err == nil {
+					return t, nil
+				}
+			}
+		}
+	}
+
+	return time.Time{}, errors.New("no creation time")
+}
+
+// isMediaFile checks by mime type if file is a video or audio
+func isMediaFile(file string) bool {
+	fileType := mime.TypeByExtension(getFileExtension(file))
+	return strings.HasPrefix(fileType, mimeTypeVideo) || strings.HasPrefix(fileType, mimeTypeAudio)
+}
+
+// parseMediaTime parses creation time tag. Times before 1971 are taken as
+// unset, as muxers write zero when they do not know the time.
+func parseMediaTime(s string) (time.Time, error) {
+	for _, l := range mediaTimeLayouts {
+		// Times without zone are UTC in mp4 and mov
+		if t, err := time.ParseInLocation(l, strings.TrimSpace(s), time.UTC); err == nil {
+			if t.Year() < 1971 {
+				return t, errors.New("creation time is not set")
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid creation time %q", s)
+}
+
+// getFilenameTime returns date and time written in name of file as time in
+// loc
+func getFilenameTime(file string, loc *time.Location) (time.Time, error) {
+	m := filenameTimePattern.FindStringSubmatch(getFileNameWithoutExtension(file))
+	if m == nil {
+		return time.Time{}, errors.New("no date in file name")
+	}
+
+	n := make([]int, 6)
+	for i, s := range m[1:] {
+		if s != "" {
+			n[i], _ = strconv.Atoi(s)
+		}
+	}
+
+	// time.Date normalizes 2019-13-40, so the date is checked by going back
+	d := time.Date(n[0], time.Month(n[1]), n[2], 0, 0, 0, 0, time.UTC)
+	if int(d.Month()) != n[1] || d.Day() != n[2] || n[3] > 23 || n[4] > 59 || n[5] > 59 {
+		return time.Time{}, errors.New("invalid date in file name")
+	}
+
+	return time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], 0, loc), nil
+}
+
+// loadLocation returns location of IANA zone name, or local zone if name is
+// local
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" || strings.ToLower(name) == "local" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
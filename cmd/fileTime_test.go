@@ -0,0 +1,107 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetFilenameTime(t *testing.T) {
+	loc := time.FixedZone("test", 5*60*60)
+
+	tests := []struct {
+		file string
+		want time.Time
+		err  bool
+	}{
+		{file: "IMG_20190503_102030.jpg", want: time.Date(2019, 5, 3, 10, 20, 30, 0, loc)},
+		{file: "/photos/2019-05-03 102030.jpg", want: time.Date(2019, 5, 3, 10, 20, 30, 0, loc)},
+		{file: "2019-05-03 10.20.30.jpg", want: time.Date(2019, 5, 3, 10, 20, 30, 0, loc)},
+		{file: "PXL_20190503_102030123.jpg", want: time.Date(2019, 5, 3, 10, 20, 30, 0, loc)},
+		{file: "VID-20190503-WA0001.mp4", want: time.Date(2019, 5, 3, 0, 0, 0, 0, loc)},
+		{file: "Screenshot 2019-05-03.png", want: time.Date(2019, 5, 3, 0, 0, 0, 0, loc)},
+		{file: "20190503.jpg", want: time.Date(2019, 5, 3, 0, 0, 0, 0, loc)},
+		{file: "holiday.jpg", err: true},
+		{file: "IMG_1234.jpg", err: true},
+		{file: "IMG_18990503_102030.jpg", err: true},
+		{file: "IMG_20191340_102030.jpg", err: true},
+		{file: "IMG_20190230.jpg", err: true},
+		{file: "IMG_20190503_256000.jpg", err: true},
+		{file: "IMG_20190503_106000.jpg", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := getFilenameTime(tt.file, loc)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("getFilenameTime(%q) = %v, want error", tt.file, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getFilenameTime(%q) error = %v", tt.file, err)
+			}
+			if !got.Equal(tt.want) || got.Location() != loc {
+				t.Fatalf("getFilenameTime(%q) = %v, want %v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMediaTime(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Time
+		err  bool
+	}{
+		{s: "2019-05-03T10:20:30.000000Z", want: time.Date(2019, 5, 3, 10, 20, 30, 0, time.UTC)},
+		{s: "2019-05-03T10:20:30+05:00", want: time.Date(2019, 5, 3, 5, 20, 30, 0, time.UTC)},
+		{s: "2019-05-03T10:20:30+0500", want: time.Date(2019, 5, 3, 5, 20, 30, 0, time.UTC)},
+		{s: "2019-05-03 10:20:30", want: time.Date(2019, 5, 3, 10, 20, 30, 0, time.UTC)},
+		{s: "2019-05-03T10:20:30", want: time.Date(2019, 5, 3, 10, 20, 30, 0, time.UTC)},
+		{s: "2019-05-03T10:20", want: time.Date(2019, 5, 3, 10, 20, 0, 0, time.UTC)},
+		{s: " 2019-05-03\n", want: time.Date(2019, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{s: "1970-01-01T00:00:00.000000Z", err: true},
+		{s: "1904-01-01 00:00:00", err: true},
+		{s: "2019:05:03 10:20:30", err: true},
+		{s: "", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseMediaTime(tt.s)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("parseMediaTime(%q) = %v, want error", tt.s, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMediaTime(%q) error = %v", tt.s, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("parseMediaTime(%q) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
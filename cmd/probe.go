@@ -0,0 +1,144 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mediaInfo is what ffprobe reports about a file
+type mediaInfo struct {
+	Format  mediaFormat   `json:"format"`
+	Streams []mediaStream `json:"streams"`
+}
+
+type mediaFormat struct {
+	FormatName string            `json:"format_name"`
+	Duration   string            `json:"duration"`
+	Tags       map[string]string `json:"tags"`
+}
+
+type mediaStream struct {
+	Index              int               `json:"index"`
+	CodecType          string            `json:"codec_type"`
+	CodecName          string            `json:"codec_name"`
+	Profile            string            `json:"profile"`
+	Width              int               `json:"width"`
+	Height             int               `json:"height"`
+	PixFmt             string            `json:"pix_fmt"`
+	SampleAspectRatio  string            `json:"sample_aspect_ratio"`
+	DisplayAspectRatio string            `json:"display_aspect_ratio"`
+	RFrameRate         string            `json:"r_frame_rate"`
+	SampleRate         string            `json:"sample_rate"`
+	Channels           int               `json:"channels"`
+	ChannelLayout      string            `json:"channel_layout"`
+	Tags               map[string]string `json:"tags"`
+	Disposition        map[string]int    `json:"disposition"`
+}
+
+// probeMedia reads format and streams of file with ffprobe
+func probeMedia(file string) (mediaInfo, error) {
+	var m mediaInfo
+
+	out, err := exec.Command(probeApp, "-v", "error",
+		"-print_format", "json",
+		"-show_format", "-show_streams", file).Output()
+	if err != nil {
+		return m, err
+	}
+
+	err = json.Unmarshal(out, &m)
+	return m, err
+}
+
+// duration returns duration of the file
+func (m mediaInfo) duration() time.Duration {
+	s, err := strconv.ParseFloat(m.Format.Duration, 64)
+	if err != nil {
+		return 0
+	}
+	return secondsToDuration(s)
+}
+
+// video returns the first video stream that is not cover art
+func (m mediaInfo) video() (mediaStream, bool) {
+	for _, s := range m.Streams {
+		if s.CodecType == "video" && s.Disposition["attached_pic"] == 0 {
+			return s, true
+		}
+	}
+	return mediaStream{}, false
+}
+
+// audio returns the first audio stream
+func (m mediaInfo) audio() (mediaStream, bool) {
+	for _, s := range m.Streams {
+		if s.CodecType == "audio" {
+			return s, true
+		}
+	}
+	return mediaStream{}, false
+}
+
+// frameRate returns frame rate of a video stream, or 0 if it is unknown
+func (s mediaStream) frameRate() float64 {
+	f, err := parseFrameRate(s.RFrameRate)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// displaySize returns width and height of a video stream as it is shown.
+// Width of anamorphic video is stretched by its sample aspect ratio.
+func (s mediaStream) displaySize() (int, int) {
+	p := strings.Split(s.SampleAspectRatio, ":")
+	if len(p) != 2 {
+		return s.Width, s.Height
+	}
+
+	n, errN := strconv.Atoi(p[0])
+	d, errD := strconv.Atoi(p[1])
+	if errN != nil || errD != nil || n <= 0 || d <= 0 {
+		return s.Width, s.Height
+	}
+
+	return int(math.Round(float64(s.Width) * float64(n) / float64(d))), s.Height
+}
+
+// startsWithKeyframe checks if the first video frame of file is a keyframe
+func startsWithKeyframe(file string) (bool, error) {
+	out, err := exec.Command(probeApp, "-v", "error",
+		"-select_streams", "v:0",
+		"-read_intervals", "%+#1",
+		"-show_entries", "frame=key_frame",
+		"-of", "csv=p=0", file).Output()
+	if err != nil {
+		return false, err
+	}
+
+	return strings.HasPrefix(strings.TrimSpace(string(out)), "1"), nil
+}
@@ -0,0 +1,114 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+// xfadeTransitions are the transitions of ffmpeg xfade filter
+var xfadeTransitions = []string{
+	"fade", "fadeblack", "fadewhite", "fadegrays", "dissolve", "pixelize",
+	"distance", "radial", "hblur",
+	"wipeleft", "wiperight", "wipeup", "wipedown",
+	"wipetl", "wipetr", "wipebl", "wipebr",
+	"slideleft", "slideright", "slideup", "slidedown",
+	"smoothleft", "smoothright", "smoothup", "smoothdown",
+	"circlecrop", "rectcrop", "circleopen", "circleclose",
+	"vertopen", "vertclose", "horzopen", "horzclose",
+	"diagtl", "diagtr", "diagbl", "diagbr",
+	"hlslice", "hrslice", "vuslice", "vdslice",
+	"squeezeh", "squeezev", "zoomin",
+}
+
+// isValidTransition checks if t is one of xfadeTransitions
+func isValidTransition(t string) bool {
+	for _, e := range xfadeTransitions {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// xfadeLoop builds filter graph that joins count copies of a clip with
+// xfade transition. Video is labelled [output]. If withAudio is true, audio
+// is joined with acrossfade of the same duration and labelled [aoutput].
+type xfadeLoop struct {
+	count      int
+	transition string
+	duration   time.Duration
+	length     time.Duration
+	withAudio  bool
+	format     videoFormat
+}
+
+// offset returns the time at which ith transition starts. Every transition
+// eats duration from the loop, so clips start length - duration apart.
+func (x xfadeLoop) offset(i int) time.Duration {
+	return time.Duration(i) * (x.length - x.duration)
+}
+
+func (x xfadeLoop) render() (string, error) {
+	g := &filtergraph.Graph{}
+	d := x.duration.Seconds()
+
+	v := filtergraph.Pads("v", x.count)
+	g.Chain([]filtergraph.Pad{"0:v"}, append(x.format.filters(),
+		filtergraph.NewFilter("settb").Arg("AVTB"),
+		filtergraph.NewFilter("split").Arg(x.count),
+	), v)
+
+	prev := v[0]
+	for i := 1; i < x.count; i++ {
+		out := filtergraph.Pad(fmt.Sprintf("x%d", i))
+		if i == x.count-1 {
+			out = "output"
+		}
+		g.Filter([]filtergraph.Pad{prev, v[i]}, filtergraph.NewFilter("xfade").
+			Set("transition", x.transition).
+			Set("duration", d).
+			Set("offset", x.offset(i).Seconds()), out)
+		prev = out
+	}
+	g.Output("output")
+
+	if x.withAudio {
+		a := filtergraph.Pads("a", x.count)
+		g.ASplit("0:a", a...)
+
+		prev = a[0]
+		for i := 1; i < x.count; i++ {
+			out := filtergraph.Pad(fmt.Sprintf("ax%d", i))
+			if i == x.count-1 {
+				out = "aoutput"
+			}
+			g.Filter([]filtergraph.Pad{prev, a[i]}, filtergraph.NewFilter("acrossfade").Set("d", d), out)
+			prev = out
+		}
+		g.Output("aoutput")
+	}
+
+	return g.Render()
+}
@@ -0,0 +1,93 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestXfadeLoopOffset(t *testing.T) {
+	tests := []struct {
+		length   time.Duration
+		duration time.Duration
+		i        int
+		want     time.Duration
+	}{
+		{10 * time.Second, 2 * time.Second, 0, 0},
+		{10 * time.Second, 2 * time.Second, 1, 8 * time.Second},
+		{10 * time.Second, 2 * time.Second, 3, 24 * time.Second},
+		{5 * time.Second, 1500 * time.Millisecond, 2, 7 * time.Second},
+	}
+
+	for _, tt := range tests {
+		x := xfadeLoop{length: tt.length, duration: tt.duration}
+		if got := x.offset(tt.i); got != tt.want {
+			t.Errorf("offset(%d) of %v with %v transition = %v, want %v", tt.i, tt.length, tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestXfadeLoopRender(t *testing.T) {
+	tests := []struct {
+		name string
+		x    xfadeLoop
+		want string
+	}{
+		{
+			name: "two copies",
+			x: xfadeLoop{count: 2, transition: "wipeleft", duration: 1500 * time.Millisecond,
+				length: 5 * time.Second},
+			want: "[0:v]settb=AVTB,split=2[v1][v2]; " +
+				"[v1][v2]xfade=transition=wipeleft:duration=1.5:offset=3.5[output]",
+		},
+		{
+			name: "three copies with audio",
+			x: xfadeLoop{count: 3, transition: "fade", duration: 2 * time.Second,
+				length: 10 * time.Second, withAudio: true},
+			want: "[0:v]settb=AVTB,split=3[v1][v2][v3]; " +
+				"[v1][v2]xfade=transition=fade:duration=2:offset=8[x1]; " +
+				"[x1][v3]xfade=transition=fade:duration=2:offset=16[output]; " +
+				"[0:a]asplit=3[a1][a2][a3]; " +
+				"[a1][a2]acrossfade=d=2[ax1]; " +
+				"[ax1][a3]acrossfade=d=2[aoutput]",
+		},
+		{
+			name: "with format",
+			x: xfadeLoop{count: 2, transition: "fade", duration: time.Second,
+				length: 4 * time.Second, format: videoFormat{fps: "30"}},
+			want: "[0:v]fps=30,settb=AVTB,split=2[v1][v2]; " +
+				"[v1][v2]xfade=transition=fade:duration=1:offset=3[output]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.x.render()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("render() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
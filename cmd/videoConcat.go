@@ -0,0 +1,469 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+// videoConcatCmd represents the videoConcat command
+var videoConcatCmd = &cobra.Command{
+	Use:   "videoConcat",
+	Short: "Join different videos one after another",
+	Long: `Joins videos in the given order. Output format is mp4.
+
+Videos are taken from the arguments and from the playlist given with -p.
+Every line of a playlist is a video with optional in and out points.
+
+  intro.mp4
+  "scene one.mp4" 00:00:02 00:00:10.5
+  outro.mp4 - 5s
+
+- is the start or the end of the video. Empty lines and lines starting with
+# are skipped. Relative paths are relative to the playlist.
+
+Videos are scaled and padded to the size of the first video and converted
+to its frame rate. If all videos have the same codecs, size, frame rate,
+aspect ratio and pixel format, and there are no in or out points or
+transitions, they are joined without encoding.
+
+--transition joins videos with an xfade transition of -t seconds.
+
+Usage:
+$ bmtool videoConcat -o eg intro.mp4 main.mp4 outro.mp4
+
+It will create "intro_concat-3.mp4" in ./eg directory
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		playlist, _ := cmd.Flags().GetString("playlist")
+		transition, _ := cmd.Flags().GetString("transition")
+		t, _ := cmd.Flags().GetString("transitionDuration")
+		tDuration, err := parseDuration(t)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		if transition != "" && !isValidTransition(transition) {
+			fmt.Fprintf(os.Stderr, "Unknown transition %v. Valid values are [%s]\n", transition, strings.Join(xfadeTransitions, "|"))
+			return
+		}
+
+		if transition != "" && tDuration <= 0 {
+			fmt.Fprint(os.Stderr, "Transition duration must be more than 0 seconds.")
+			return
+		}
+
+		var entries []concatEntry
+		if playlist != "" {
+			entries, err = readPlaylist(playlist)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		}
+		for _, e := range args {
+			entries = append(entries, concatEntry{file: e})
+		}
+
+		if len(entries) < 2 {
+			fmt.Fprint(os.Stderr, "At least 2 videos are required.")
+			return
+		}
+
+		for i := range entries {
+			if err := entries[i].probe(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s \t %s\n", entries[i].file, err)
+				return
+			}
+
+			if transition != "" && entries[i].duration() <= tDuration {
+				fmt.Fprintf(os.Stderr, "%s is shorter than the transition\n", entries[i].file)
+				return
+			}
+		}
+
+		oPath := createOutputDirectory(cmd)
+		output := getOutputFileName(oPath, entries[0].file, "concat", strconv.Itoa(len(entries)), "mp4")
+
+		if transition == "" && canConcatWithoutEncoding(entries) {
+			concatWithoutEncoding(entries, output)
+		} else {
+			concatWithEncoding(entries, output, transition, tDuration)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(videoConcatCmd)
+
+	videoConcatCmd.Flags().StringP("playlist", "p", "", "Playlist file. One video per line with optional in and out points")
+	videoConcatCmd.Flags().String("transition", "", "Transition between videos. Any ffmpeg xfade transition e.g. fade|wipeleft|slideup. Default none")
+	videoConcatCmd.Flags().StringP("transitionDuration", "t", "1", "Transition duration e.g. 1, 1.5s or 00:00:01.500. Default 1 second.")
+	videoConcatCmd.Flags().StringP("outputDirectory", "o", "", "Output directory path. Default is current.")
+}
+
+// concatEntry is a video of videoConcat with its in and out points
+type concatEntry struct {
+	file string
+	in   time.Duration
+	// out is zero for the end of video
+	out  time.Duration
+	info mediaInfo
+}
+
+// probe checks that entry is a video and reads its streams
+func (c *concatEntry) probe() error {
+	if !isFileVideo(c.file) {
+		return errors.New("is not a video")
+	}
+
+	info, err := probeMedia(c.file)
+	if err != nil {
+		return err
+	}
+	if _, ok := info.video(); !ok {
+		return errors.New("has no video stream")
+	}
+	c.info = info
+
+	if c.in >= c.info.duration() || (c.out > 0 && c.out <= c.in) {
+		return errors.New("in and out points are outside of the video")
+	}
+	return nil
+}
+
+// duration returns duration of the entry between its in and out points
+func (c concatEntry) duration() time.Duration {
+	end := c.info.duration()
+	if c.out > 0 && c.out < end {
+		end = c.out
+	}
+	return end - c.in
+}
+
+// readPlaylist reads entries of a playlist file
+func readPlaylist(playlist string) ([]concatEntry, error) {
+	f, err := os.Open(playlist)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []concatEntry
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		e, err := parsePlaylistLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", playlist, n, err)
+		}
+
+		if !filepath.IsAbs(e.file) {
+			e.file = filepath.Join(filepath.Dir(playlist), e.file)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// parsePlaylistLine parses a line like
+//
+//	"file name.mp4" 00:00:02 10.5s
+func parsePlaylistLine(line string) (concatEntry, error) {
+	var e concatEntry
+
+	if strings.HasPrefix(line, `"`) {
+		i := strings.Index(line[1:], `"`)
+		if i < 0 {
+			return e, errors.New("missing closing quote")
+		}
+		e.file = line[1 : i+1]
+		line = line[i+2:]
+	} else {
+		f := strings.Fields(line)
+		e.file = f[0]
+		line = strings.TrimPrefix(line, f[0])
+	}
+
+	points := strings.Fields(line)
+	if len(points) > 2 {
+		return e, errors.New("too many fields")
+	}
+
+	for i, p := range points {
+		if p == "-" {
+			continue
+		}
+
+		d, err := parseDuration(p)
+		if err != nil {
+			return e, err
+		}
+		if i == 0 {
+			e.in = d
+		} else {
+			e.out = d
+		}
+	}
+
+	return e, nil
+}
+
+// canConcatWithoutEncoding checks if concat demuxer can join entries as
+// they are into mp4
+func canConcatWithoutEncoding(entries []concatEntry) bool {
+	reason := ""
+	mp4 := videoEncoding{container: "mp4"}
+
+	first, _ := entries[0].info.video()
+	firstAudio, firstHasAudio := entries[0].info.audio()
+
+	for _, e := range entries {
+		v, _ := e.info.video()
+		a, hasAudio := e.info.audio()
+
+		switch {
+		case e.in > 0 || e.out > 0:
+			reason = e.file + " has in or out point"
+		case !mp4.canHold("video", v.CodecName):
+			reason = "mp4 can not hold video codec " + v.CodecName + " of " + e.file
+		case hasAudio && !mp4.canHold("audio", a.CodecName):
+			reason = "mp4 can not hold audio codec " + a.CodecName + " of " + e.file
+		case v.CodecName != first.CodecName:
+			reason = e.file + " has video codec " + v.CodecName
+		case v.Width != first.Width || v.Height != first.Height:
+			reason = fmt.Sprintf("%s is %dx%d", e.file, v.Width, v.Height)
+		case v.RFrameRate != first.RFrameRate:
+			reason = e.file + " has frame rate " + v.RFrameRate
+		case v.SampleAspectRatio != first.SampleAspectRatio:
+			reason = e.file + " has aspect ratio " + v.SampleAspectRatio
+		case v.PixFmt != first.PixFmt:
+			reason = e.file + " has pixel format " + v.PixFmt
+		case hasAudio != firstHasAudio:
+			reason = e.file + " differs in having audio"
+		case hasAudio && (a.CodecName != firstAudio.CodecName ||
+			a.SampleRate != firstAudio.SampleRate || a.Channels != firstAudio.Channels):
+			reason = e.file + " has different audio"
+		}
+
+		if reason != "" {
+			break
+		}
+	}
+
+	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+		if reason == "" {
+			fmt.Println("Videos are compatible. Joining without encoding")
+		} else {
+			fmt.Printf("Encoding because %s\n", reason)
+		}
+	}
+
+	return reason == ""
+}
+
+func concatWithoutEncoding(entries []concatEntry, output string) {
+	files := make([]string, len(entries))
+	for i, e := range entries {
+		files[i] = e.file
+	}
+
+	list, err := writeConcatList(filepath.Dir(output), files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer os.Remove(list) // clean up
+
+	err = runFFmpeg([]string{"-hide_banner",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", list,
+		"-map", "0:v", "-map", "0:a?",
+		"-c", "copy", "-movflags", "+faststart",
+		output})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func concatWithEncoding(entries []concatEntry, output string, transition string, tDuration time.Duration) {
+	if transition != "" && !hasFilter("xfade") {
+		fmt.Fprintln(os.Stderr, "ffmpeg has no xfade filter. Transitions need ffmpeg 4.3 or newer")
+		return
+	}
+
+	var a []string
+	a = append(a, "-hide_banner")
+	for _, e := range entries {
+		if e.in > 0 {
+			a = append(a, "-ss", formatSeconds(e.in))
+		}
+		if e.out > 0 {
+			a = append(a, "-t", formatSeconds(e.duration()))
+		}
+		a = append(a, "-i", e.file)
+	}
+
+	fc, withAudio, err := filterComplexConcat(entries, transition, tDuration)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+		fmt.Printf("filter_complex is\n%s\n", fc)
+	}
+
+	a = append(a, mp4Option...)
+	a = append(a, "-filter_complex", fc, "-map", "[output]")
+	if withAudio {
+		a = append(a, "-map", "[aoutput]", "-c:a", "aac")
+	}
+	a = append(a, output)
+
+	if err := runFFmpeg(a); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// filterComplexConcat converts every entry to the size and frame rate of
+// the first one and joins them. Entries without audio get silence if any
+// other entry has audio.
+func filterComplexConcat(entries []concatEntry, transition string, tDuration time.Duration) (string, bool, error) {
+	g := &filtergraph.Graph{}
+
+	first, _ := entries[0].info.video()
+	fps := first.RFrameRate
+	if first.frameRate() <= 0 {
+		fps = "30"
+	}
+
+	withAudio := false
+	for _, e := range entries {
+		if _, ok := e.info.audio(); ok {
+			withAudio = true
+		}
+	}
+
+	v := filtergraph.Pads("v", len(entries))
+	a := filtergraph.Pads("a", len(entries))
+	for i, e := range entries {
+		g.Chain([]filtergraph.Pad{filtergraph.Pad(fmt.Sprintf("%d:v", i))}, []filtergraph.Filter{
+			filtergraph.NewFilter("scale").Set("w", first.Width).Set("h", first.Height).
+				Set("force_original_aspect_ratio", "decrease"),
+			filtergraph.NewFilter("pad").Set("w", first.Width).Set("h", first.Height).
+				Set("x", "(ow-iw)/2").Set("y", "(oh-ih)/2"),
+			filtergraph.NewFilter("setsar").Arg(1),
+			filtergraph.NewFilter("fps").Arg(fps),
+			filtergraph.NewFilter("format").Arg("yuv420p"),
+			filtergraph.NewFilter("setpts").Arg("PTS-STARTPTS"),
+		}, []filtergraph.Pad{v[i]})
+
+		if !withAudio {
+			continue
+		}
+
+		audioFormat := filtergraph.NewFilter("aformat").
+			Set("sample_fmts", "fltp").Set("sample_rates", 48000).Set("channel_layouts", "stereo")
+
+		if _, ok := e.info.audio(); ok {
+			g.Chain([]filtergraph.Pad{filtergraph.Pad(fmt.Sprintf("%d:a", i))}, []filtergraph.Filter{
+				audioFormat,
+				filtergraph.NewFilter("asetpts").Arg("PTS-STARTPTS"),
+			}, []filtergraph.Pad{a[i]})
+		} else {
+			g.Chain(nil, []filtergraph.Filter{
+				filtergraph.NewFilter("anullsrc").Set("r", 48000).Set("cl", "stereo"),
+				filtergraph.NewFilter("atrim").Set("duration", e.duration().Seconds()),
+				audioFormat,
+			}, []filtergraph.Pad{a[i]})
+		}
+	}
+
+	if transition == "" {
+		segments := make([][]filtergraph.Pad, len(entries))
+		for i := range segments {
+			segments[i] = []filtergraph.Pad{v[i]}
+			if withAudio {
+				segments[i] = append(segments[i], a[i])
+			}
+		}
+
+		if withAudio {
+			g.Concat(segments, 1, 1, "output", "aoutput")
+			g.Output("output", "aoutput")
+		} else {
+			g.Concat(segments, 1, 0, "output")
+			g.Output("output")
+		}
+
+		fc, err := g.Render()
+		return fc, withAudio, err
+	}
+
+	d := tDuration.Seconds()
+	length := entries[0].duration()
+	prev, aprev := v[0], a[0]
+	for i := 1; i < len(entries); i++ {
+		out, aout := filtergraph.Pad(fmt.Sprintf("x%d", i)), filtergraph.Pad(fmt.Sprintf("ax%d", i))
+		if i == len(entries)-1 {
+			out, aout = "output", "aoutput"
+		}
+
+		// Transition starts tDuration before the end of what is joined so far
+		g.Filter([]filtergraph.Pad{prev, v[i]}, filtergraph.NewFilter("xfade").
+			Set("transition", transition).
+			Set("duration", d).
+			Set("offset", (length-tDuration).Seconds()), out)
+		if withAudio {
+			g.Filter([]filtergraph.Pad{aprev, a[i]}, filtergraph.NewFilter("acrossfade").Set("d", d), aout)
+		}
+
+		length += entries[i].duration() - tDuration
+		prev, aprev = out, aout
+	}
+
+	g.Output("output")
+	if withAudio {
+		g.Output("aoutput")
+	}
+
+	fc, err := g.Render()
+	return fc, withAudio, err
+}
@@ -0,0 +1,172 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+const (
+	fitPad     = "pad"
+	fitCrop    = "crop"
+	fitStretch = "stretch"
+)
+
+// videoFormat is frame size, frame rate and aspect ratio of an output. Zero
+// value keeps those of the input.
+type videoFormat struct {
+	width  int
+	height int
+	// fit is how a frame of different aspect ratio fits the size
+	fit string
+	fps string
+	sar string
+}
+
+// addVideoFormatFlags adds flags read by newVideoFormat to cmd
+func addVideoFormatFlags(cmd *cobra.Command) {
+	cmd.Flags().String("scale", "", "Output size WxH e.g. 1920x1080. Default is size of the input")
+	cmd.Flags().String("fit", fitPad, "How video fits --scale. pad keeps all of it and adds bars, crop fills the frame, stretch changes its aspect ratio. pad|crop|stretch")
+	cmd.Flags().String("fps", "", "Output frame rate e.g. 30 or 30000/1001. Default is frame rate of the input")
+	cmd.Flags().String("sar", "", "Output sample aspect ratio e.g. 1:1. Default is 1:1 with --scale")
+}
+
+// newVideoFormat reads format flags of cmd
+func newVideoFormat(cmd *cobra.Command) (videoFormat, error) {
+	var f videoFormat
+
+	scale, _ := cmd.Flags().GetString("scale")
+	f.fit, _ = cmd.Flags().GetString("fit")
+	f.fps, _ = cmd.Flags().GetString("fps")
+	f.sar, _ = cmd.Flags().GetString("sar")
+
+	if scale != "" {
+		var err error
+		f.width, f.height, err = parseSize(scale)
+		if err != nil {
+			return f, err
+		}
+	}
+
+	if f.fit != fitPad && f.fit != fitCrop && f.fit != fitStretch {
+		return f, fmt.Errorf("unknown fit %v, valid values are [%s|%s|%s]", f.fit, fitPad, fitCrop, fitStretch)
+	}
+
+	if f.fps != "" {
+		if _, err := parseFrameRate(f.fps); err != nil {
+			return f, fmt.Errorf("invalid frame rate %q", f.fps)
+		}
+	}
+
+	if f.sar != "" {
+		if _, err := parseFrameRate(strings.Replace(f.sar, ":", "/", 1)); err != nil {
+			return f, fmt.Errorf("invalid sample aspect ratio %q", f.sar)
+		}
+		f.sar = strings.Replace(f.sar, ":", "/", 1)
+	}
+
+	return f, nil
+}
+
+// parseSize parses size like 1920x1080
+func parseSize(s string) (int, int, error) {
+	p := strings.Split(strings.ToLower(s), "x")
+	if len(p) != 2 {
+		return 0, 0, fmt.Errorf("invalid size %q, use WxH", s)
+	}
+
+	w, errW := strconv.Atoi(p[0])
+	h, errH := strconv.Atoi(p[1])
+	if errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid size %q, use WxH", s)
+	}
+
+	return w, h, nil
+}
+
+// isZero checks if format keeps the input as it is
+func (f videoFormat) isZero() bool {
+	return f.width == 0 && f.fps == "" && f.sar == ""
+}
+
+// filters returns filters that convert a video to the format
+func (f videoFormat) filters() []filtergraph.Filter {
+	var fl []filtergraph.Filter
+
+	if f.width > 0 {
+		scale := filtergraph.NewFilter("scale").Set("w", f.width).Set("h", f.height)
+		switch f.fit {
+		case fitPad:
+			fl = append(fl, scale.Set("force_original_aspect_ratio", "decrease"),
+				filtergraph.NewFilter("pad").Set("w", f.width).Set("h", f.height).
+					Set("x", "(ow-iw)/2").Set("y", "(oh-ih)/2"))
+		case fitCrop:
+			fl = append(fl, scale.Set("force_original_aspect_ratio", "increase"),
+				filtergraph.NewFilter("crop").Set("w", f.width).Set("h", f.height))
+		default:
+			fl = append(fl, scale)
+		}
+	}
+
+	if f.sar != "" {
+		fl = append(fl, filtergraph.NewFilter("setsar").Arg(f.sar))
+	} else if f.width > 0 {
+		fl = append(fl, filtergraph.NewFilter("setsar").Arg(1))
+	}
+
+	if f.fps != "" {
+		fl = append(fl, filtergraph.NewFilter("fps").Arg(f.fps))
+	}
+
+	return fl
+}
+
+// apply converts in to the format in graph g and returns n copies of it
+// named name1 ... nameN. If format has nothing to convert, in is returned
+// n times.
+func (f videoFormat) apply(g *filtergraph.Graph, in filtergraph.Pad, name string, n int) []filtergraph.Pad {
+	fl := f.filters()
+	if len(fl) == 0 {
+		p := make([]filtergraph.Pad, n)
+		for i := range p {
+			p[i] = in
+		}
+		return p
+	}
+
+	out := filtergraph.Pads(name, n)
+	g.Chain([]filtergraph.Pad{in}, append(fl, filtergraph.NewFilter("split").Arg(n)), out)
+	return out
+}
+
+// videoFilter returns filters for -vf, or empty string if there are none
+func (f videoFormat) videoFilter() string {
+	fl := f.filters()
+	if len(fl) == 0 {
+		return ""
+	}
+	return filtergraph.Chain{Filters: fl}.String()
+}
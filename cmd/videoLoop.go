@@ -23,15 +23,16 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/talha131/bmtool/filtergraph"
 )
 
 // videoLoopCmd represents the videoLoop command
@@ -39,52 +40,228 @@ var videoLoopCmd = &cobra.Command{
 	Use:   "videoLoop",
 	Short: "Concatenate same video multiple times to create a loop",
 	Long: `Creates loop of a video by concatenating it multiple times.
-Output format is mp4.
+Output format is mp4. --container, --vcodec, --crf, --preset, --max-bitrate
+and --pix-fmt change the output. Encoders and pixel formats are checked
+against the ones of local ffmpeg. Without --vcodec, webm is encoded with
+libvpx-vp9 and other containers with libx264.
+
+--format gif|webp makes an animated image of the loop instead of a video.
+GIF uses a palette made for the loop in a first pass and --dither. Size
+of the animation is limited by --max-width and --max-fps. With --max-size
+it is made smaller till it fits.
+
+--scale, --fps and --sar convert the video before it is looped, so
+transitions are made at the output size and frame rate. --fit tells how a
+video of different aspect ratio fits --scale.
+
+Without -x streams are copied, which is almost instant, if the selected
+container can hold their codecs and the video starts with a keyframe. Otherwise the loop is
+encoded. Output tells which one is done and why.
 
 -c and -l are mutually exclusive. -c has precedence over -l.
+
+-l and -t take seconds (90, 90.5), a duration (1m30.5s) or a clock time
+(00:01:30.500). With -l output is cut to exactly the given length.
+
+--transition picks the xfade transition used by -x, e.g. fade, wipeleft,
+slideup, circleopen, dissolve or pixelize. Setting it turns on -x. If local
+ffmpeg has no xfade filter, loop falls back to a plain cross fade.
+
+--mode pingpong plays the video forward and then in reverse, over and over.
+It is for clips that do not loop seamlessly. -x is ignored in this mode.
+
+--find-loop-point looks for frames near the end of the video that match its
+first frames and cuts the video there, so the loop needs no transition.
+The match score is printed. If it is below --loop-threshold the loop uses
+a cross fade instead.
+
+--audio decides what happens to the audio of the video.
+  keep           audio is looped along with the video. With -x each loop
+                 is joined with an audio cross fade of -t seconds.
+  drop           output has no audio.
+  replace:<file> audio file is used as the soundtrack of the loop.
+
+--match-audio takes the length from an audio file instead of -l. The loop is
+cut to the length of the audio and the audio is used as its soundtrack.
+It implies --audio replace:<file>.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		count, errC := cmd.Flags().GetInt("count")
-		requiredLength, errD := cmd.Flags().GetInt("length")
+		l, _ := cmd.Flags().GetString("length")
+		requiredLength, errD := parseDuration(l)
 		crossFade, _ := cmd.Flags().GetBool("withCrossFade")
-		tDuration, _ := cmd.Flags().GetInt("transitionDuration")
+		transition, _ := cmd.Flags().GetString("transition")
+		t, _ := cmd.Flags().GetString("transitionDuration")
+		tDuration, errT := parseDuration(t)
+		audioOption, _ := cmd.Flags().GetString("audio")
+		matchAudio, _ := cmd.Flags().GetString("match-audio")
+		mode, _ := cmd.Flags().GetString("mode")
+		findLoop, _ := cmd.Flags().GetBool("find-loop-point")
+		threshold, _ := cmd.Flags().GetFloat64("loop-threshold")
+		s, _ := cmd.Flags().GetString("loop-search")
+		search, errS := parseDuration(s)
 
 		if errC != nil && errD != nil {
 			fmt.Fprint(os.Stderr, "Unable to find Count or Length. At least one is required")
 			return
 		}
 
+		if errD != nil {
+			fmt.Fprintln(os.Stderr, errD)
+			return
+		}
+
+		if errT != nil {
+			fmt.Fprintln(os.Stderr, errT)
+			return
+		}
+
+		if !isValidTransition(transition) {
+			fmt.Fprintf(os.Stderr, "Unknown transition %v. Valid values are [%s]\n", transition, strings.Join(xfadeTransitions, "|"))
+			return
+		}
+
+		if cmd.Flags().Changed("transition") {
+			crossFade = true
+		}
+
+		if mode != loopNormal && mode != loopPingPong {
+			fmt.Fprintf(os.Stderr, "Unknown mode %v. Valid values are [%s|%s]\n", mode, loopNormal, loopPingPong)
+			return
+		}
+
+		if errS != nil || search <= 0 {
+			fmt.Fprintf(os.Stderr, "Invalid loop search duration %v\n", s)
+			return
+		}
+
+		if findLoop && mode == loopPingPong {
+			fmt.Fprint(os.Stderr, "--find-loop-point does not work with ping-pong loop.")
+			return
+		}
+
+		if mode == loopPingPong && crossFade {
+			fmt.Fprintln(os.Stderr, "Ping-pong loop has no transition. Ignoring -x")
+			crossFade = false
+		}
+
+		if crossFade && tDuration <= 0 {
+			fmt.Fprint(os.Stderr, "Transition duration must be more than 0 seconds.")
+			return
+		}
+
 		if count < 2 {
 			fmt.Fprint(os.Stderr, "Loop count must be at least 2.")
 			return
 		}
 
+		if matchAudio != "" {
+			audioOption = "replace:" + matchAudio
+		}
+
+		audio, err := parseLoopAudio(audioOption)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		if matchAudio != "" {
+			d, err := getDuration(matchAudio)
+			if err != nil || d <= 0 {
+				fmt.Fprintf(os.Stderr, "Unable to get duration of %s\n", matchAudio)
+				return
+			}
+			requiredLength = d
+		}
+
+		enc, err := newVideoEncoding(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		enc.format, err = newVideoFormat(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		anim, err := newAnimation(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		// Animation is made from a loop encoded for it
+		ext, loopEnc := enc.extension(), enc
+		if anim.format != "" {
+			ext, loopEnc = anim.format, anim.intermediate(enc)
+		}
+
 		oPath := createOutputDirectory(cmd)
 		shouldConcatCountTimes := requiredLength == 0 && errC == nil && count > 2
 		shouldConcatToAchieveLength := !shouldConcatCountTimes && errD == nil && requiredLength > 0
 
+		fallbackDuration := tDuration
 		if !crossFade {
 			tDuration = 0
 		}
 
 		for _, e := range args {
 			if isFileVideo(e) {
-				if shouldConcatCountTimes {
-					outputFileName := getOutputFileName(oPath, e, "loop", count)
-					createVideoLoop(count, e, outputFileName, tDuration, crossFade)
+				var cut time.Duration
+				tr, td := transitionOption(crossFade, transition), tDuration
+				if findLoop {
+					cut = getLoopPoint(e, search, threshold)
+					if cut == 0 {
+						tr, td = transition, fallbackDuration
+					}
+				}
+
+				var outputFileName string
+				var create func(output string)
+
+				if shouldConcatCountTimes && mode == loopPingPong {
+					outputFileName = getOutputFileName(oPath, e, "pingpong", strconv.Itoa(count), ext)
+					create = func(o string) { createPingPongLoop(count, e, o, audio, 0, loopEnc) }
+				} else if shouldConcatCountTimes && cut > 0 {
+					outputFileName = getOutputFileName(oPath, e, "loop", strconv.Itoa(count), ext)
+					create = func(o string) { createVideoLoopAtPoint(count, e, o, cut, audio, 0, loopEnc) }
+				} else if shouldConcatCountTimes {
+					outputFileName = getOutputFileName(oPath, e, "loop", strconv.Itoa(count), ext)
+					create = func(o string) { createVideoLoop(count, e, o, td, tr, audio, 0, loopEnc) }
 				} else if shouldConcatToAchieveLength {
-					length, err := getLength(e)
-					if err != nil && length <= 0 {
-						continue
+					length := cut
+					if length == 0 {
+						length, err = getDuration(e)
+						if err != nil || length <= 0 {
+							continue
+						}
 					}
 
-					count, err := getRequiredLoopCount(length, requiredLength, tDuration)
+					count, err := getRequiredLoopCount(length, requiredLength, td)
 					if err != nil {
 						continue
 					}
 
-					outputFileName := getOutputFileName(oPath, e, "length", requiredLength)
-					createVideoLoop(count, e, outputFileName, tDuration, crossFade)
+					if mode == loopPingPong {
+						outputFileName = getOutputFileName(oPath, e, "pingpong-length", formatSeconds(requiredLength), ext)
+						create = func(o string) { createPingPongLoop(count, e, o, audio, requiredLength, loopEnc) }
+					} else if cut > 0 {
+						outputFileName = getOutputFileName(oPath, e, "length", formatSeconds(requiredLength), ext)
+						create = func(o string) { createVideoLoopAtPoint(count, e, o, cut, audio, requiredLength, loopEnc) }
+					} else {
+						outputFileName = getOutputFileName(oPath, e, "length", formatSeconds(requiredLength), ext)
+						create = func(o string) { createVideoLoop(count, e, o, td, tr, audio, requiredLength, loopEnc) }
+					}
+				} else {
+					continue
+				}
+
+				if anim.format == "" {
+					create(outputFileName)
+				} else {
+					anim.create(outputFileName, create)
 				}
 			}
 		}
@@ -95,78 +272,268 @@ func init() {
 	rootCmd.AddCommand(videoLoopCmd)
 
 	videoLoopCmd.Flags().IntP("count", "c", 3, "Number of times to concatenate the video. Minimum 2. Default 3.")
-	videoLoopCmd.Flags().IntP("length", "l", 0, "Minimum seconds of the video. Default 0 seconds")
+	videoLoopCmd.Flags().StringP("length", "l", "0", "Length of the video e.g. 90, 1m30.5s or 00:01:30.500. Default 0 seconds")
 	videoLoopCmd.Flags().BoolP("withCrossFade", "x", false, "Concatenate videos with cross fade transition. Default false.")
-	videoLoopCmd.Flags().IntP("transitionDuration", "t", 2, "Transition duration. Default 2 seconds.")
+	videoLoopCmd.Flags().StringP("transitionDuration", "t", "2", "Transition duration e.g. 2, 1.5s or 00:00:01.500. Default 2 seconds.")
 	videoLoopCmd.Flags().StringP("outputDirectory", "o", "", "Output directory path. Default is current.")
+	videoLoopCmd.Flags().String("mode", loopNormal, "Loop mode. normal|pingpong")
+	videoLoopCmd.Flags().Bool("find-loop-point", false, "Cut video where it matches its start, so it loops without transition")
+	videoLoopCmd.Flags().Float64("loop-threshold", 0.9, "Lowest match score of a loop point. 0 to 1. Default 0.9")
+	videoLoopCmd.Flags().String("loop-search", "3s", "Searches loop point in this much of the end of video. Default 3s")
+	videoLoopCmd.Flags().String("transition", "fade", "Transition used with -x. Any ffmpeg xfade transition e.g. fade|wipeleft|slideup|circleopen|dissolve|pixelize")
+	addVideoEncodingFlags(videoLoopCmd)
+	addVideoFormatFlags(videoLoopCmd)
+	addAnimationFlags(videoLoopCmd)
+	videoLoopCmd.Flags().String("audio", "keep", "Audio of the loop. keep|drop|replace:<file>")
+	videoLoopCmd.Flags().String("match-audio", "", "Audio file whose length is used as -l. It becomes the soundtrack of the loop.")
+}
+
+const (
+	loopNormal   = "normal"
+	loopPingPong = "pingpong"
+)
+
+const (
+	audioKeep    = "keep"
+	audioDrop    = "drop"
+	audioReplace = "replace"
+)
+
+// loopAudio tells what to do with the audio of a loop
+type loopAudio struct {
+	mode string
+	// file is the soundtrack when mode is replace
+	file string
 }
 
-func createVideoLoop(count int, e string, outputFileName string, tDuration int, crossFade bool) {
-	if crossFade {
-		createVideoLoopWithTransition(count, tDuration, e, outputFileName)
+// parseLoopAudio parses value of --audio flag
+func parseLoopAudio(s string) (loopAudio, error) {
+	switch {
+	case s == audioKeep || s == audioDrop:
+		return loopAudio{mode: s}, nil
+	case strings.HasPrefix(s, audioReplace+":"):
+		f := strings.TrimPrefix(s, audioReplace+":")
+		if !isFileAudio(f) {
+			return loopAudio{}, fmt.Errorf("%s is not an audio file", f)
+		}
+		return loopAudio{mode: audioReplace, file: f}, nil
+	}
+
+	return loopAudio{}, fmt.Errorf("unknown audio %v, valid values are [keep|drop|replace:<file>]", s)
+}
+
+// forFile resolves keep to drop if the video has no audio to keep
+func (a loopAudio) forFile(file string) loopAudio {
+	if a.mode == audioKeep && !hasAudioStream(file) {
+		if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+			fmt.Printf("%s has no audio\n", file)
+		}
+		return loopAudio{mode: audioDrop}
+	}
+	return a
+}
+
+// inputOption returns extra ffmpeg input required by the audio
+func (a loopAudio) inputOption() []string {
+	if a.mode == audioReplace {
+		return []string{"-i", a.file}
+	}
+	return nil
+}
+
+// trimOption returns ffmpeg option that cuts output at length. Zero length
+// keeps the whole output.
+func trimOption(length time.Duration) []string {
+	if length <= 0 {
+		return nil
+	}
+	return []string{"-t", formatSeconds(length)}
+}
+
+// mapOption returns ffmpeg options that map video and audio of the loop
+// to the output. keep maps audio label, replace maps the soundtrack and
+// cuts the output at the end of it. Soundtrack is the input after the
+// first inputs files.
+func (a loopAudio) mapOption(video string, audio string, inputs int) []string {
+	switch a.mode {
+	case audioKeep:
+		return []string{"-map", video, "-map", audio}
+	case audioReplace:
+		return []string{"-map", video, "-map", fmt.Sprintf("%d:a", inputs), "-shortest"}
+	}
+	return []string{"-map", video, "-an"}
+}
+
+// transitionOption returns transition of the loop. Empty string means no
+// transition.
+func transitionOption(crossFade bool, transition string) string {
+	if !crossFade {
+		return ""
+	}
+	return transition
+}
+
+// createVideoLoop loops e count times. If length is not zero, output is cut
+// to it.
+func createVideoLoop(count int, e string, outputFileName string, tDuration time.Duration, transition string, audio loopAudio, length time.Duration, enc videoEncoding) {
+	audio = audio.forFile(e)
+	if transition != "" {
+		createVideoLoopWithTransition(count, tDuration, transition, e, outputFileName, audio, length, enc)
 	} else {
-		createVideoLoopWithoutTransition(count, e, outputFileName)
+		createVideoLoopWithoutTransition(count, e, outputFileName, audio, length, enc)
 	}
 }
 
-func filterComplexWithCrossFade(count int, tDur int, length int) (filter string) {
+// filterComplexWithCrossFade returns filter graph of the loop. Video is
+// labelled [output]. If withAudio is true, audio is joined with the same
+// cross fade and labelled [aoutput].
+func filterComplexWithCrossFade(count int, tDur time.Duration, length time.Duration, withAudio bool, format videoFormat) (string, error) {
+	g := &filtergraph.Graph{}
+
+	t := tDur.Seconds()
+	cut := (length - tDur).Seconds()
+	l := length.Seconds()
 
-	cf := ""
-	cl := ""
-	cfcl := ""
-	for i := 1; i < count; i++ {
-		cf = cf + fmt.Sprintf("[cf%d]", i)
-		cl = cl + fmt.Sprintf("[cl%d]", i)
-		cfcl = cfcl + fmt.Sprintf("[cf%d][cl%d]", i, i)
+	trim := func(start float64, end float64) []filtergraph.Filter {
+		return []filtergraph.Filter{
+			filtergraph.NewFilter("trim").Set("start", start).Set("end", end),
+			filtergraph.NewFilter("setpts").Arg("PTS-STARTPTS"),
+		}
+	}
+	fade := func(t string, d float64) []filtergraph.Filter {
+		return []filtergraph.Filter{
+			filtergraph.NewFilter("format").Set("pix_fmts", "yuva420p"),
+			filtergraph.NewFilter("fade").Set("t", t).Set("st", 0).Set("d", d).Set("alpha", 1),
+		}
 	}
+	// Video is converted to the output format once, before it is cut
+	in := format.apply(g, "0:v", "src", 5)
 
 	// length = 15, tDur = 5
-	filter = filter + fmt.Sprintf("[0:v]trim=start=0:end=%d,setpts=PTS-STARTPTS[clip1]; ", length-tDur)               // 0 - 10
-	filter = filter + fmt.Sprintf("[0:v]trim=start=%d:end=%d,setpts=PTS-STARTPTS[clip2]; ", tDur, length-tDur)        // 5 - 10
-	filter = filter + fmt.Sprintf("[0:v]trim=start=%d:end=%d,setpts=PTS-STARTPTS[clip3]; ", length-tDur, length)      // 10 - 15
-	filter = filter + fmt.Sprintf("[0:v]trim=start=%d:end=%d,setpts=PTS-STARTPTS[fadeoutsrc]; ", length-tDur, length) // 10 - 15
-	filter = filter + fmt.Sprintf("[0:v]trim=start=0:end=%d,setpts=PTS-STARTPTS[fadeinsrc]; ", tDur)                  // 0 - 5
+	g.Chain(in[0:1], trim(0, cut), []filtergraph.Pad{"clip1"})      // 0 - 10
+	g.Chain(in[1:2], trim(t, cut), []filtergraph.Pad{"clip2"})      // 5 - 10
+	g.Chain(in[2:3], trim(cut, l), []filtergraph.Pad{"clip3"})      // 10 - 15
+	g.Chain(in[3:4], trim(cut, l), []filtergraph.Pad{"fadeoutsrc"}) // 10 - 15
+	g.Chain(in[4:5], trim(0, t), []filtergraph.Pad{"fadeinsrc"})    // 0 - 5
+
+	g.Chain([]filtergraph.Pad{"fadeinsrc"}, fade("in", t), []filtergraph.Pad{"fadein"})
+	g.Chain([]filtergraph.Pad{"fadeoutsrc"}, fade("out", t), []filtergraph.Pad{"fadeout"})
+
+	g.Filter([]filtergraph.Pad{"fadein"}, filtergraph.NewFilter("fifo"), "fadeinfifo")
+	g.Filter([]filtergraph.Pad{"fadeout"}, filtergraph.NewFilter("fifo"), "fadeoutfifo")
+	g.Overlay("fadeoutfifo", "fadeinfifo", "crossfade")
+
+	cf := filtergraph.Pads("cf", count-1)
+	cl := filtergraph.Pads("cl", count-1)
+	g.Split("crossfade", cf...)
+	g.Split("clip2", cl...)
+
+	var acf, acl []filtergraph.Pad
+	if withAudio {
+		acf, acl = filterComplexAudioWithCrossFade(g, count, tDur, length)
+	}
+
+	// Final number of clips to concatenate is twice of count
+	segment := func(v filtergraph.Pad, a filtergraph.Pad) []filtergraph.Pad {
+		if withAudio {
+			return []filtergraph.Pad{v, a}
+		}
+		return []filtergraph.Pad{v}
+	}
+	segments := [][]filtergraph.Pad{segment("clip1", "aclip1")}
+	for i := 0; i < count-1; i++ {
+		if withAudio {
+			segments = append(segments, segment(cf[i], acf[i]), segment(cl[i], acl[i]))
+		} else {
+			segments = append(segments, segment(cf[i], ""), segment(cl[i], ""))
+		}
+	}
+	segments = append(segments, segment("clip3", "aclip3"))
+
+	if withAudio {
+		g.Concat(segments, 1, 1, "output", "aoutput")
+		g.Output("output", "aoutput")
+	} else {
+		g.Concat(segments, 1, 0, "output")
+		g.Output("output")
+	}
 
-	filter = filter + fmt.Sprintf("[fadeinsrc]format=pix_fmts=yuva420p, fade=t=in:st=0:d=%d:alpha=1[fadein]; ", tDur)
-	filter = filter + fmt.Sprintf("[fadeoutsrc]format=pix_fmts=yuva420p, fade=t=out:st=0:d=%d:alpha=1[fadeout]; ", tDur)
+	return g.Render()
+}
 
-	filter = filter + "[fadein]fifo[fadeinfifo]; "
-	filter = filter + "[fadeout]fifo[fadeoutfifo]; "
-	filter = filter + "[fadeoutfifo][fadeinfifo]overlay[crossfade]; "
+// filterComplexAudioWithCrossFade cuts audio at the same points as the video
+// in filterComplexWithCrossFade. Tail and head of the audio are joined with
+// acrossfade, so every segment has the duration of its video segment.
+// It returns pads of the cross fades and of the middle clips.
+func filterComplexAudioWithCrossFade(g *filtergraph.Graph, count int, tDur time.Duration, length time.Duration) (acf []filtergraph.Pad, acl []filtergraph.Pad) {
+	t := tDur.Seconds()
+	cut := (length - tDur).Seconds()
+	l := length.Seconds()
+
+	trim := func(start float64, end float64) []filtergraph.Filter {
+		return []filtergraph.Filter{
+			filtergraph.NewFilter("atrim").Set("start", start).Set("end", end),
+			filtergraph.NewFilter("asetpts").Arg("PTS-STARTPTS"),
+		}
+	}
+	in := []filtergraph.Pad{"0:a"}
 
-	filter = filter + fmt.Sprintf("[crossfade] split=%d %s ; ", count-1, cf)
-	filter = filter + fmt.Sprintf("[clip2] split=%d %s ; ", count-1, cl)
+	g.Chain(in, trim(0, cut), []filtergraph.Pad{"aclip1"})
+	g.Chain(in, trim(t, cut), []filtergraph.Pad{"aclip2"})
+	g.Chain(in, trim(cut, l), []filtergraph.Pad{"aclip3"})
+	g.Chain(in, trim(cut, l), []filtergraph.Pad{"afadeoutsrc"})
+	g.Chain(in, trim(0, t), []filtergraph.Pad{"afadeinsrc"})
 
-	filter = filter + "[clip1]" + cfcl + "[clip3]"
-	// Final number of clips to concatenate is twice of count
-	filter = filter + fmt.Sprintf("concat=n=%d:v=1[output]", count*2)
+	g.Filter([]filtergraph.Pad{"afadeoutsrc", "afadeinsrc"},
+		filtergraph.NewFilter("acrossfade").Set("d", t), "acrossfade")
+
+	acf = filtergraph.Pads("acf", count-1)
+	acl = filtergraph.Pads("acl", count-1)
+	g.ASplit("acrossfade", acf...)
+	g.ASplit("aclip2", acl...)
 
-	return filter
+	return acf, acl
 }
 
-func createVideoLoopWithTransition(count int, tDur int, file string, outputFileName string) {
-	length, err := getLength(file)
+func createVideoLoopWithTransition(count int, tDur time.Duration, transition string, file string, outputFileName string, audio loopAudio, trim time.Duration, enc videoEncoding) {
+	length, err := getDuration(file)
 	if err != nil {
 		return
 	}
 
-	if length < tDur {
+	if length <= tDur {
 		fmt.Fprint(os.Stderr, "Transition duration must be less than video length")
+		return
 	}
 
-	fc := filterComplexWithCrossFade(count, tDur, length)
+	var fc string
+	if hasFilter("xfade") {
+		fc, err = xfadeLoop{count: count, transition: transition, duration: tDur,
+			length: length, withAudio: audio.mode == audioKeep, format: enc.format}.render()
+	} else {
+		if transition != "fade" {
+			fmt.Fprintf(os.Stderr, "ffmpeg has no xfade filter. Using fade instead of %s\n", transition)
+		}
+		fc, err = filterComplexWithCrossFade(count, tDur, length, audio.mode == audioKeep, enc.format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
 
 	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
 		fmt.Printf("filter_complex is\n%s\n", fc)
 	}
 
-	cmd := exec.Command(app, "-hide_banner",
-		"-i", file,
-		"-f", "mp4", "-vcodec", "libx264", "-preset", "veryfast", "-profile:v", "main", "-movflags", "+faststart",
-		"-an", "-filter_complex",
-		fc,
-		"-map", "[output]",
-		outputFileName)
+	a := []string{"-hide_banner", "-i", file}
+	a = append(a, audio.inputOption()...)
+	a = append(a, enc.args()...)
+	a = append(a, "-filter_complex", fc)
+	a = append(a, audio.mapOption("[output]", "[aoutput]", 1)...)
+	a = append(a, trimOption(trim)...)
+	a = append(a, outputFileName)
+
+	cmd := exec.Command(app, a...)
 
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
@@ -176,7 +543,7 @@ func createVideoLoopWithTransition(count int, tDur int, file string, outputFileN
 	}
 }
 
-func getRequiredLoopCount(length int, requiredLength int, tDuration int) (int, error) {
+func getRequiredLoopCount(length time.Duration, requiredLength time.Duration, tDuration time.Duration) (int, error) {
 	if requiredLength == 0 {
 		fmt.Fprintf(os.Stderr, "Required length is invalid")
 		return 0, errors.New("required duration is 0")
@@ -189,10 +556,21 @@ func getRequiredLoopCount(length int, requiredLength int, tDuration int) (int, e
 	// lastClip is transitionDuration
 	// firstClip is fileLength - transitionDuration
 	// count = (requiredLength - tDuration) / (length - tDuration)
+	if length <= tDuration {
+		fmt.Fprintf(os.Stderr, "Transition duration must be less than video length")
+		return 0, errors.New("transition is longer than video")
+	}
+
 	numerator := float64(requiredLength - tDuration)
 	denominator := float64(length - tDuration)
 	requiredLoop := int(math.Ceil(numerator / denominator))
 
+	// Loop is cut to the required length, so a single clip is
+	// still looped at least twice.
+	if requiredLoop < 2 {
+		requiredLoop = 2
+	}
+
 	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
 		fmt.Printf("Loop %d times\n", requiredLoop)
 	}
@@ -200,50 +578,102 @@ func getRequiredLoopCount(length int, requiredLength int, tDuration int) (int, e
 	return requiredLoop, nil
 }
 
-func getOutputFileName(oPath string, f string, t string, num int) string {
-	fn := fmt.Sprintf("%s_%s-%d.mp4", getFileNameWithoutExtension(f),
+func getOutputFileName(oPath string, f string, t string, num string, ext string) string {
+	fn := fmt.Sprintf("%s_%s-%s.%s", getFileNameWithoutExtension(f),
 		t,
-		num)
+		num, ext)
 	return filepath.Join(oPath, fn)
 }
 
-func createVideoLoopWithoutTransition(count int, e string, output string) {
-	tmpFile, err := ioutil.TempFile(filepath.Dir(e), getFileNameWithoutExtension(e))
+func createVideoLoopWithoutTransition(count int, e string, output string, audio loopAudio, length time.Duration, enc videoEncoding) {
+	files := make([]string, count)
+	for i := range files {
+		files[i] = e
+	}
+
+	list, err := writeConcatList(filepath.Dir(e), files)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, err)
+		return
 	}
+	defer os.Remove(list) // clean up
 
-	defer os.Remove(tmpFile.Name()) // clean up
+	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+		fmt.Printf("list is\n%v\n", list)
+	}
 
-	p, _ := filepath.Abs(e)
+	reason := streamCopyReason(e, audio, enc)
+	if reason == "" {
+		fmt.Printf("%s \t copying streams, codecs fit %s and video starts with a keyframe\n", e, enc.container)
+	} else {
+		fmt.Printf("%s \t encoding, %s\n", e, reason)
+	}
 
-	line := fmt.Sprintf("%s '%s'\n", "file", p)
+	runCommandVideoLoopWithoutTransition(list,
+		output, audio, length, reason == "", enc)
+}
 
-	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
-		fmt.Printf("file is\n%v\n", line)
+// streamCopyReason returns why loop of file can not be made by copying its
+// streams. Empty string means it can.
+func streamCopyReason(file string, audio loopAudio, enc videoEncoding) string {
+	if enc.custom {
+		return "encoder options are set"
 	}
 
-	lineR := strings.Repeat(line, int(count))
+	if !enc.format.isZero() {
+		return "size, frame rate or aspect ratio is changed"
+	}
 
-	if _, err := tmpFile.WriteString(lineR); err != nil {
-		log.Fatal(err)
+	info, err := probeMedia(file)
+	if err != nil {
+		return err.Error()
 	}
-	if err := tmpFile.Close(); err != nil {
-		log.Fatal(err)
+
+	v, ok := info.video()
+	if !ok {
+		return "video stream is not found"
+	}
+	if !enc.canHold("video", v.CodecName) {
+		return enc.container + " can not hold video codec " + v.CodecName
+	}
+
+	if a, ok := info.audio(); ok && audio.mode == audioKeep && !enc.canHold("audio", a.CodecName) {
+		return enc.container + " can not hold audio codec " + a.CodecName
 	}
 
-	runCommandVideoLoopWithoutTransition(tmpFile.Name(),
-		output)
+	// Every copy of the video must start with a keyframe, else the frames
+	// after the joint can not be decoded
+	key, err := startsWithKeyframe(file)
+	if err != nil {
+		return err.Error()
+	}
+	if !key {
+		return "video does not start with a keyframe"
+	}
+
+	return ""
 }
 
-func runCommandVideoLoopWithoutTransition(file string, output string) {
+func runCommandVideoLoopWithoutTransition(file string, output string, audio loopAudio, length time.Duration, streamCopy bool, enc videoEncoding) {
 
-	cmd := exec.Command(app, "-hide_banner",
+	a := []string{"-hide_banner",
 		"-f", "concat",
 		"-safe", "0",
-		"-i", file,
-		"-qscale:v", "0",
-		output)
+		"-i", file}
+	a = append(a, audio.inputOption()...)
+	if streamCopy {
+		a = append(a, enc.copyArgs(audio.mode == audioKeep)...)
+	} else {
+		a = append(a, enc.args()...)
+		if vf := enc.format.videoFilter(); vf != "" {
+			a = append(a, "-vf", vf)
+		}
+	}
+	a = append(a, audio.mapOption("0:v", "0:a", 1)...)
+	a = append(a, trimOption(length)...)
+	a = append(a, output)
+
+	cmd := exec.Command(app, a...)
 
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
@@ -0,0 +1,289 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+var ditherModes = []string{"none", "bayer", "heckbert", "floyd_steinberg", "sierra2", "sierra2_4a"}
+
+const (
+	// animationAttempts is how many times an animation is made smaller to
+	// fit --max-size
+	animationAttempts = 4
+	// minAnimationWidth is the smallest width tried to fit --max-size
+	minAnimationWidth = 64
+)
+
+// animation is an animated gif or webp made from a loop. Zero value makes
+// a video instead.
+type animation struct {
+	format   string
+	dither   string
+	maxWidth int
+	maxFps   float64
+	// maxSize is the target file size in bytes. Zero means no target.
+	maxSize int64
+}
+
+// addAnimationFlags adds flags read by newAnimation to cmd
+func addAnimationFlags(cmd *cobra.Command) {
+	cmd.Flags().String("format", "video", "Output format. video|gif|webp")
+	cmd.Flags().String("dither", "sierra2_4a", "Dithering of gif. "+strings.Join(ditherModes, "|"))
+	cmd.Flags().Int("max-width", 480, "Largest width of gif or webp. 0 keeps the width")
+	cmd.Flags().Float64("max-fps", 15, "Largest frame rate of gif or webp. 0 keeps the frame rate")
+	cmd.Flags().String("max-size", "", "Target file size of gif or webp e.g. 5M or 800k")
+}
+
+// newAnimation reads animation flags of cmd
+func newAnimation(cmd *cobra.Command) (animation, error) {
+	var a animation
+
+	format, _ := cmd.Flags().GetString("format")
+	a.dither, _ = cmd.Flags().GetString("dither")
+	a.maxWidth, _ = cmd.Flags().GetInt("max-width")
+	a.maxFps, _ = cmd.Flags().GetFloat64("max-fps")
+	size, _ := cmd.Flags().GetString("max-size")
+
+	switch format {
+	case "video":
+		return animation{}, nil
+	case "gif", "webp":
+		a.format = format
+	default:
+		return a, fmt.Errorf("unknown format %v, valid values are [video|gif|webp]", format)
+	}
+
+	if indexOf(ditherModes, a.dither) < 0 {
+		return a, fmt.Errorf("unknown dither %v, valid values are [%s]", a.dither, strings.Join(ditherModes, "|"))
+	}
+
+	if a.maxWidth < 0 || a.maxFps < 0 {
+		return a, fmt.Errorf("--max-width and --max-fps can not be negative")
+	}
+
+	if size != "" {
+		var err error
+		a.maxSize, err = parseFileSize(size)
+		if err != nil {
+			return a, err
+		}
+	}
+
+	if a.format == "webp" && !hasEncoder("libwebp") {
+		return a, fmt.Errorf("ffmpeg has no libwebp encoder")
+	}
+
+	return a, nil
+}
+
+// parseFileSize parses size like 800k, 5M or 5MB in bytes
+func parseFileSize(s string) (int64, error) {
+	n := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(n, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(n, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(n, "G"):
+		unit = 1 << 30
+	}
+	n = strings.TrimRight(n, "KMG")
+
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(f * float64(unit)), nil
+}
+
+// intermediate returns encoding of the loop the animation is made from.
+// It is close to lossless so the animation is encoded from a clean source.
+func (a animation) intermediate(enc videoEncoding) videoEncoding {
+	return videoEncoding{container: "mkv", vcodec: "libx264", crf: 12,
+		preset: "veryfast", format: enc.format}
+}
+
+// create makes the loop with create in a temporary file and converts it to
+// animation output
+func (a animation) create(output string, create func(string)) {
+	tmpDir, err := ioutil.TempDir(filepath.Dir(output), getFileNameWithoutExtension(output))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir) // clean up
+
+	loop := filepath.Join(tmpDir, "loop.mkv")
+	create(loop)
+
+	if _, err := os.Stat(loop); err != nil {
+		fmt.Fprintln(os.Stderr, "Loop is not created")
+		return
+	}
+
+	fps, err := getFrameRate(loop)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	// Attempts are written in tmpDir, so only they are overwritten
+	attempt := filepath.Join(tmpDir, "animation."+a.format)
+	width := a.maxWidth
+	for i := 0; i < animationAttempts; i++ {
+		f := a.filters(width, fps)
+		if a.format == "gif" {
+			err = a.createGif(loop, attempt, f, filepath.Join(tmpDir, "palette.png"))
+		} else {
+			err = a.createWebp(loop, attempt, f)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		fi, err := os.Stat(attempt)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		if a.maxSize == 0 || fi.Size() <= a.maxSize {
+			if err := moveFile(attempt, output); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return
+		}
+
+		// Size of an animation is about proportional to its area
+		if width == 0 {
+			width, err = getWidth(loop)
+			if err != nil {
+				break
+			}
+		}
+		width = int(float64(width) * math.Sqrt(float64(a.maxSize)/float64(fi.Size())) * 0.95)
+		if width < minAnimationWidth {
+			break
+		}
+
+		if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+			fmt.Printf("%s is %d bytes. Trying width %d\n", output, fi.Size(), width)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Unable to fit %s in %d bytes\n", output, a.maxSize)
+	if err := moveFile(attempt, output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// filters returns filters that limit frame rate and width of the loop. fps
+// is frame rate of the loop.
+func (a animation) filters(width int, fps float64) []filtergraph.Filter {
+	var f []filtergraph.Filter
+	if a.maxFps > 0 && fps > a.maxFps {
+		f = append(f, filtergraph.NewFilter("fps").Arg(a.maxFps))
+	}
+	if width > 0 {
+		f = append(f, filtergraph.NewFilter("scale").
+			Set("w", fmt.Sprintf("min(iw,%d)", width)).Set("h", -1).Set("flags", "lanczos"))
+	}
+	return f
+}
+
+// createGif makes gif in two passes. First pass makes a palette of the
+// loop, second pass maps the loop to it.
+func (a animation) createGif(loop string, output string, filters []filtergraph.Filter, palette string) error {
+	g := &filtergraph.Graph{}
+	g.Chain([]filtergraph.Pad{"0:v"}, append(filters,
+		filtergraph.NewFilter("palettegen").Set("stats_mode", "diff")), []filtergraph.Pad{"palette"})
+	g.Output("palette")
+
+	fc, err := g.Render()
+	if err != nil {
+		return err
+	}
+
+	err = runFFmpeg([]string{"-hide_banner", "-y", "-i", loop,
+		"-filter_complex", fc, "-map", "[palette]", "-frames:v", "1", "-update", "1", palette})
+	if err != nil {
+		return err
+	}
+
+	g = &filtergraph.Graph{}
+	if len(filters) > 0 {
+		g.Chain([]filtergraph.Pad{"0:v"}, filters, []filtergraph.Pad{"video"})
+		g.Filter([]filtergraph.Pad{"video", "1:v"}, filtergraph.NewFilter("paletteuse").
+			Set("dither", a.dither).Set("diff_mode", "rectangle"), "output")
+	} else {
+		g.Filter([]filtergraph.Pad{"0:v", "1:v"}, filtergraph.NewFilter("paletteuse").
+			Set("dither", a.dither).Set("diff_mode", "rectangle"), "output")
+	}
+	g.Output("output")
+
+	fc, err = g.Render()
+	if err != nil {
+		return err
+	}
+
+	return runFFmpeg([]string{"-hide_banner", "-y", "-i", loop, "-i", palette,
+		"-filter_complex", fc, "-map", "[output]", "-loop", "0", output})
+}
+
+// createWebp makes animated webp of the loop
+func (a animation) createWebp(loop string, output string, filters []filtergraph.Filter) error {
+	args := []string{"-hide_banner", "-y", "-i", loop}
+	if len(filters) > 0 {
+		args = append(args, "-vf", filtergraph.Chain{Filters: filters}.String())
+	}
+	args = append(args, "-an", "-c:v", "libwebp", "-lossless", "0", "-q:v", "75",
+		"-compression_level", "6", "-loop", "0", output)
+
+	return runFFmpeg(args)
+}
+
+// getWidth returns width of the first video stream of file
+func getWidth(file string) (int, error) {
+	info, err := probeMedia(file)
+	if err != nil {
+		return 0, err
+	}
+
+	v, ok := info.video()
+	if !ok {
+		return 0, fmt.Errorf("%s has no video stream", file)
+	}
+	return v.Width, nil
+}
@@ -0,0 +1,178 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+// pingPongSegment is the longest part of a clip that is reversed at once.
+// reverse filter keeps every frame of its input in memory, so longer clips
+// are reversed in parts.
+const pingPongSegment = 10 * time.Second
+
+// intermediateOption is the encoding of temporary files
+var intermediateOption = []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-c:a", "pcm_s16le"}
+
+// createPingPongLoop plays file forward and in reverse count times in total.
+// If length is not zero, output is cut to it.
+//
+// Forward and reversed clips are encoded once and joined with the concat
+// demuxer, so memory does not grow with the length of the clip or count.
+func createPingPongLoop(count int, file string, output string, audio loopAudio, length time.Duration, enc videoEncoding) {
+	audio = audio.forFile(file)
+	withAudio := audio.mode == audioKeep
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(file), getFileNameWithoutExtension(file))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir) // clean up
+
+	// Both clips must have the same codecs to be joined, so the forward
+	// clip is encoded as well
+	forward := filepath.Join(tmpDir, "forward.mkv")
+	a := []string{"-hide_banner", "-i", file}
+	if !withAudio {
+		a = append(a, "-an")
+	}
+	a = append(a, intermediateOption...)
+	if err := runFFmpeg(append(a, forward)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	reversed, err := reverseVideo(forward, tmpDir, withAudio)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	files := make([]string, count)
+	for i := range files {
+		files[i] = forward
+		if i%2 == 1 {
+			files[i] = reversed
+		}
+	}
+
+	list, err := writeConcatList(tmpDir, files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	runCommandVideoLoopWithoutTransition(list, output, audio, length, false, enc)
+}
+
+// reverseVideo writes file played backwards in dir and returns its path.
+// Clips longer than pingPongSegment are cut in parts, every part is
+// reversed on its own and parts are joined last to first.
+//
+// The first and the last frame of file are dropped, as they are shown by
+// the forward clips on either side of the reversed one.
+func reverseVideo(file string, dir string, withAudio bool) (string, error) {
+	length, err := getDuration(file)
+	if err != nil {
+		return "", err
+	}
+
+	fps, err := getFrameRate(file)
+	if err != nil {
+		return "", err
+	}
+	frame := secondsToDuration(1 / fps).Seconds()
+
+	// Parts are of the same length, so the last one is never a few frames
+	n := int(math.Ceil(float64(length) / float64(pingPongSegment)))
+	if n < 1 {
+		n = 1
+	}
+	partLength := length / time.Duration(n)
+
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = filepath.Join(dir, fmt.Sprintf("reversed%d.mkv", i))
+
+		var vf, af []filtergraph.Filter
+		if i == 0 {
+			vf = append(vf, filtergraph.NewFilter("trim").Set("start_frame", 1),
+				filtergraph.NewFilter("setpts").Arg("PTS-STARTPTS"))
+			af = append(af, filtergraph.NewFilter("atrim").Set("start", frame),
+				filtergraph.NewFilter("asetpts").Arg("PTS-STARTPTS"))
+		}
+		vf = append(vf, filtergraph.NewFilter("reverse"))
+		af = append(af, filtergraph.NewFilter("areverse"))
+		if i == n-1 {
+			vf = append(vf, filtergraph.NewFilter("trim").Set("start_frame", 1),
+				filtergraph.NewFilter("setpts").Arg("PTS-STARTPTS"))
+			af = append(af, filtergraph.NewFilter("atrim").Set("start", frame),
+				filtergraph.NewFilter("asetpts").Arg("PTS-STARTPTS"))
+		}
+
+		a := []string{"-hide_banner"}
+		if i > 0 {
+			a = append(a, "-ss", formatSeconds(partLength*time.Duration(i)))
+		}
+		if i < n-1 {
+			a = append(a, "-t", formatSeconds(partLength))
+		}
+		a = append(a, "-i", file, "-vf", filtergraph.Chain{Filters: vf}.String())
+		if withAudio {
+			a = append(a, "-af", filtergraph.Chain{Filters: af}.String())
+		} else {
+			a = append(a, "-an")
+		}
+		a = append(a, intermediateOption...)
+		a = append(a, parts[i])
+
+		if err := runFFmpeg(a); err != nil {
+			return "", err
+		}
+	}
+
+	if n == 1 {
+		return parts[0], nil
+	}
+
+	reversed := make([]string, n)
+	for i, p := range parts {
+		reversed[n-1-i] = p
+	}
+
+	list, err := writeConcatList(dir, reversed)
+	if err != nil {
+		return "", err
+	}
+
+	output := filepath.Join(dir, "reversed.mkv")
+	return output, runFFmpeg([]string{"-hide_banner",
+		"-f", "concat", "-safe", "0", "-i", list,
+		"-c", "copy", output})
+}
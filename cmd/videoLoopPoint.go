@@ -0,0 +1,197 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+const (
+	// signatureSize is width and height of a frame signature
+	signatureSize = 16
+	// loopPointFrames is the number of frames compared at every candidate
+	// loop point. Comparing a few frames also matches the motion.
+	loopPointFrames = 5
+)
+
+// frameSignature is luma of a frame downscaled to signatureSize pixels
+// square
+type frameSignature []byte
+
+// frameSignatures returns signatures of frames of file starting at start.
+// If frames is zero, it reads till the end of file.
+func frameSignatures(file string, start time.Duration, frames int) ([]frameSignature, error) {
+	a := []string{"-v", "error"}
+	if start > 0 {
+		a = append(a, "-ss", formatSeconds(start))
+	}
+	a = append(a, "-i", file)
+	if frames > 0 {
+		a = append(a, "-frames:v", fmt.Sprint(frames))
+	}
+	a = append(a, "-an",
+		"-vf", filtergraph.Chain{Filters: []filtergraph.Filter{
+			filtergraph.NewFilter("scale").Set("w", signatureSize).Set("h", signatureSize).Set("flags", "area"),
+			filtergraph.NewFilter("format").Arg("gray"),
+		}}.String(),
+		"-f", "rawvideo", "-")
+
+	out, err := exec.Command(app, a...).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	size := signatureSize * signatureSize
+	var s []frameSignature
+	for i := 0; i+size <= len(out); i += size {
+		s = append(s, frameSignature(out[i:i+size]))
+	}
+
+	return s, nil
+}
+
+// difference returns mean absolute difference of two signatures. 0 is same
+// and 1 is opposite.
+func (f frameSignature) difference(o frameSignature) float64 {
+	var sum float64
+	for i := range f {
+		sum += math.Abs(float64(f[i]) - float64(o[i]))
+	}
+	return sum / float64(len(f)) / 255
+}
+
+// findLoopPoint searches last search duration of file for frames that look
+// like its first frames. It returns the time to cut file at, so that the
+// cut continues into the first frame, and a score of the match. Score is 1
+// for identical frames.
+func findLoopPoint(file string, search time.Duration) (time.Duration, float64, error) {
+	length, err := getDuration(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fps, err := getFrameRate(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	head, err := frameSignatures(file, 0, loopPointFrames)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Never cut away more than half of the clip
+	start := length - search
+	if start < length/2 {
+		start = length / 2
+	}
+
+	tail, err := frameSignatures(file, start, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(head) < loopPointFrames || len(tail) < loopPointFrames {
+		return 0, 0, errors.New("too few frames to find loop point")
+	}
+
+	best, bestDiff := 0, math.MaxFloat64
+	for i := 0; i+loopPointFrames <= len(tail); i++ {
+		var diff float64
+		for j := 0; j < loopPointFrames; j++ {
+			diff += tail[i+j].difference(head[j])
+		}
+
+		if diff < bestDiff {
+			best, bestDiff = i, diff
+		}
+	}
+
+	cut := start + secondsToDuration(float64(best)/fps)
+	return cut, 1 - bestDiff/loopPointFrames, nil
+}
+
+// getLoopPoint prints loop point of file and its score. It returns zero if
+// there is no loop point with score of at least threshold.
+func getLoopPoint(file string, search time.Duration, threshold float64) time.Duration {
+	cut, score, err := findLoopPoint(file, search)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to find loop point of %s\t%s\n", file, err)
+		return 0
+	}
+
+	fmt.Printf("%s \t loop point %ss \t score %.3f\n", file, formatSeconds(cut), score)
+
+	if score < threshold {
+		fmt.Fprintf(os.Stderr, "Score of %s is below %v. Using cross fade\n", file, threshold)
+		return 0
+	}
+	return cut
+}
+
+// createVideoLoopAtPoint cuts file at cut and loops it count times without
+// transition. If length is not zero, output is cut to it.
+//
+// Cut clip is encoded once and joined count times with the concat demuxer,
+// so memory does not grow with count.
+func createVideoLoopAtPoint(count int, file string, output string, cut time.Duration, audio loopAudio, length time.Duration, enc videoEncoding) {
+	audio = audio.forFile(file)
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(file), getFileNameWithoutExtension(file))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir) // clean up
+
+	clip := filepath.Join(tmpDir, "clip.mkv")
+	a := []string{"-hide_banner", "-i", file, "-t", formatSeconds(cut)}
+	if audio.mode != audioKeep {
+		a = append(a, "-an")
+	}
+	a = append(a, intermediateOption...)
+	if err := runFFmpeg(append(a, clip)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	files := make([]string, count)
+	for i := range files {
+		files[i] = clip
+	}
+
+	list, err := writeConcatList(tmpDir, files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	runCommandVideoLoopWithoutTransition(list, output, audio, length, false, enc)
+}
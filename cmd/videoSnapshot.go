@@ -21,12 +21,17 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/talha131/bmtool/filtergraph"
 )
 
 // videoSnapshotCmd represents the videoSnapshot command
@@ -39,36 +44,183 @@ Default is to take snapshot at 2nd second i.e. 00::00::2.0
 if -m flag is used then snapshot is taken from the middle of the video.
 If video is 30 minute long, then it will take snaptshot right at 00:15:00.
 
-Default output format is png. If output format is set to jpeg then it is exported
-at highest quality.
+-t takes snapshot at a timestamp. A timestamp is seconds e.g. 12.48, a clock
+time e.g. 00:01:30.500, a percentage of the video e.g. 50% or a frame number
+e.g. f:1234. Time of the frame that is taken is printed.
+
+Snapshot is taken with an input seek, which is quick and lands on the frame at
+the timestamp. --accurate seeks to a point before the timestamp and then decodes
+frames up to it, for files whose seeking is not exact.
+
+Default output format is png. jpg, webp and avif are also supported. Quality of
+jpg, webp and avif is set by --quality from 1 to 100. jpg is exported at highest
+quality by default.
+
+Snapshot has the display aspect ratio of the video, so frames of anamorphic video
+are stretched. --width and --height resize the snapshot. If only one of them is
+set, the other keeps the aspect ratio.
+
+--overlay draws text on snapshots. Text is a template that can show {{.File}},
+{{.Path}} and {{.Timestamp}} of the frame e.g. --overlay "{{.File}} {{.Timestamp}}"
+Font, size, color, box and position of text are set by --overlay-* flags. On a
+contact sheet, text is drawn on every frame in place of its timestamp. Sprites
+have no overlay.
+
+--embed embeds the snapshot in the video as its cover art. See coverArt command.
+
+--at takes snapshots at many timestamps e.g. --at 5,00:01:30,50%,f:1234
+
+--every takes a snapshot at every interval e.g. --every 30s
+
+--count takes that many evenly spaced snapshots.
+
+--at, --every and --count read the video once for all of the snapshots.
+Snapshot file name of --at and --count has the timestamp asked in seconds, so
+it is the same as of a single snapshot at that timestamp. Snapshot file name of
+--every has the time of the frame in seconds.
+
+--contact-sheet makes a single image of a grid of evenly spaced frames e.g.
+--contact-sheet 4x4. Every frame shows its timestamp, and the header shows file
+name, duration, resolution and codecs. --width and --height set the size of every
+frame of the sheet, which is 320 pixels wide by default.
+
+--smart compares frames around the snapshot time and saves the best one. Frames
+are scored by brightness, contrast, sharpness and how well they represent the
+others, so black frames, fades and blurred frames are skipped. Use -v to see
+the scores or --json to print them as JSON.
+
+--scenes takes a snapshot at the start of every scene. A new scene starts when
+a frame differs from the previous one by more than --threshold, from 0 to 1.
+Start and end of every scene is written to a list next to the snapshots, in
+json or csv format as set by --scene-list.
+
+--sprite makes jpg sprite pages for scrubbing previews of a web player, and a
+WebVTT file that maps time to thumbnails in them. A thumbnail is taken every
+--interval and pages are --tile thumbnails big e.g.
+--sprite --interval 5s --tile 10x10 --width 160
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		format, _ := cmd.Flags().GetString("format")
-		if format != "png" && format != "jpg" {
-			fmt.Fprintf(os.Stderr, "Unknown format %v. Valid values are [png|jpg]\n", format)
+		img, err := newSnapshotImage(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		at, _ := cmd.Flags().GetStringSlice("at")
+		every, _ := cmd.Flags().GetString("every")
+		count, _ := cmd.Flags().GetInt("count")
+		sheet, _ := cmd.Flags().GetString("contact-sheet")
+		smart, _ := cmd.Flags().GetBool("smart")
+		scenes, _ := cmd.Flags().GetBool("scenes")
+		sprite, _ := cmd.Flags().GetBool("sprite")
+
+		modes := 0
+		for _, m := range []bool{len(at) > 0, every != "", count > 0, sheet != "", smart, scenes, sprite} {
+			if m {
+				modes++
+			}
+		}
+		timestamp, _ := cmd.Flags().GetString("timestamp")
+		if mid, _ := cmd.Flags().GetBool("mid"); mid && timestamp != "" {
+			fmt.Fprint(os.Stderr, "-m and -t are mutually exclusive.")
+			return
+		}
+
+		if modes > 1 {
+			fmt.Fprint(os.Stderr, "--at, --every, --count, --contact-sheet, --smart, --scenes and --sprite are mutually exclusive.")
+			return
+		}
+
+		if sprite && img.overlay.template != nil {
+			fmt.Fprint(os.Stderr, "--overlay cannot be used with --sprite.")
+			return
+		}
+
+		embed, _ := cmd.Flags().GetBool("embed")
+		if embed && modes > 0 && !smart {
+			fmt.Fprint(os.Stderr, "--embed works with a single snapshot. It cannot be used with --at, --every, --count, --contact-sheet, --scenes or --sprite.")
+			return
+		}
+		if embed && img.format != "png" && img.format != "jpg" {
+			fmt.Fprint(os.Stderr, "--embed needs png or jpg format.")
+			return
+		}
+
+		threshold, _ := cmd.Flags().GetFloat64("threshold")
+		sceneList, _ := cmd.Flags().GetString("scene-list")
+		if scenes && (threshold <= 0 || threshold >= 1) {
+			fmt.Fprintf(os.Stderr, "Invalid threshold %v. It must be between 0 and 1\n", threshold)
+			return
+		}
+		if scenes && sceneList != "json" && sceneList != "csv" {
+			fmt.Fprintf(os.Stderr, "Unknown scene list %v. Valid values are [json|csv]\n", sceneList)
 			return
 		}
 
 		oPath := createOutputDirectory(cmd)
 		for _, e := range args {
-			if isFileVideo(e) {
-				timestamp := "2"
-				if d, e := getLength(e); e != nil || d < 2 {
+			if !isFileVideo(e) {
+				continue
+			}
+
+			switch {
+			case sheet != "":
+				createContactSheet(e, oPath, img, sheet)
+			case sprite:
+				interval, _ := cmd.Flags().GetString("interval")
+				tile, _ := cmd.Flags().GetString("tile")
+				width, _ := cmd.Flags().GetInt("width")
+				createSprite(e, oPath, interval, tile, width)
+			case scenes:
+				createSceneSnapshots(e, oPath, img, threshold, sceneList)
+			case len(at) > 0 || every != "" || count > 0:
+				createVideoSnapshots(e, oPath, img, at, every, count)
+			default:
+				d, err := getDuration(e)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to get duration of %s\t%s\n", e, err)
 					continue
 				}
 
-				if m, _ := cmd.Flags().GetBool("mid"); m {
-
-					var err error
-					timestamp, err = getMidTimestamp(e)
+				t := 2 * time.Second
+				if timestamp != "" {
+					t, err = parseTimestamp(timestamp, d, e)
 					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
 						continue
 					}
+				} else if m, _ := cmd.Flags().GetBool("mid"); m {
+					t = d / 2
+				} else if d < t {
+					continue
 				}
 
-				f := fmt.Sprintf("%s-%s.%s", getFileNameWithoutExtension(e), timestamp, format)
-				of := filepath.Join(oPath, f)
-				createVideoSnapshot(timestamp, e, of)
+				if smart {
+					window, _ := cmd.Flags().GetString("smart-window")
+					w, err := parseDuration(window)
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						return
+					}
+					asJSON, _ := cmd.Flags().GetBool("json")
+					if of := createSmartSnapshot(e, oPath, img, t, w, asJSON); of != "" && embed {
+						embedImage(e, of)
+					}
+					continue
+				}
+
+				accurate, _ := cmd.Flags().GetBool("accurate")
+				of := getSnapshotFileName(oPath, e, formatSeconds(t), img.format)
+				pts, err := createVideoSnapshot(t, e, of, img, accurate)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					continue
+				}
+				fmt.Printf("%s \t frame at %ss \t %s\n", e, formatSeconds(pts), of)
+
+				if embed {
+					embedImage(e, of)
+				}
 			}
 		}
 	},
@@ -77,38 +229,206 @@ at highest quality.
 func init() {
 	rootCmd.AddCommand(videoSnapshotCmd)
 	videoSnapshotCmd.Flags().BoolP("mid", "m", false, "Take snapshot from mid")
-	videoSnapshotCmd.Flags().StringP("format", "f", "png", "Output format. png|jpg")
+	videoSnapshotCmd.Flags().StringP("timestamp", "t", "", "Take snapshot at timestamp e.g. 12.48, 00:01:30.500, 50% or f:1234")
+	videoSnapshotCmd.Flags().Bool("accurate", false, "Seek to the exact frame")
+	videoSnapshotCmd.Flags().Bool("embed", false, "Embed snapshot as cover art of the video")
 	videoSnapshotCmd.Flags().StringP("outputDirectory", "o", "", "Output directory path. Default is current.")
+	addSnapshotImageFlags(videoSnapshotCmd)
+	videoSnapshotCmd.Flags().StringSlice("at", nil, "Take snapshots at timestamps e.g. 5,00:01:30,50%")
+	videoSnapshotCmd.Flags().String("every", "", "Take a snapshot every interval e.g. 30s")
+	videoSnapshotCmd.Flags().Int("count", 0, "Take this many evenly spaced snapshots")
+	videoSnapshotCmd.Flags().String("contact-sheet", "", "Make contact sheet of columns x rows frames e.g. 4x4")
+	videoSnapshotCmd.Flags().Bool("smart", false, "Pick the best frame around the snapshot time")
+	videoSnapshotCmd.Flags().String("smart-window", "3s", "Compare frames this much before and after the snapshot time")
+	videoSnapshotCmd.Flags().Bool("json", false, "Print scores of --smart as JSON")
+	videoSnapshotCmd.Flags().Bool("scenes", false, "Take a snapshot of every scene")
+	videoSnapshotCmd.Flags().Float64("threshold", 0.3, "Scene change threshold from 0 to 1")
+	videoSnapshotCmd.Flags().String("scene-list", "json", "Format of list of scenes. json|csv")
+	videoSnapshotCmd.Flags().Bool("sprite", false, "Make sprite pages and WebVTT for scrubbing previews")
+	videoSnapshotCmd.Flags().String("interval", "5s", "Time between thumbnails of --sprite")
+	videoSnapshotCmd.Flags().String("tile", "10x10", "Columns x rows of thumbnails in a sprite page")
 }
 
-func getMidTimestamp(file string) (string, error) {
-	fileDuration, err := getLength(file)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to get duration of %s\t%s", file, err)
-		return "00", err
+func getSnapshotFileName(oPath string, file string, timestamp string, format string) string {
+	f := fmt.Sprintf("%s-%s.%s", getFileNameWithoutExtension(file), timestamp, format)
+	return filepath.Join(oPath, f)
+}
+
+// parseTimestamp parses timestamp of video file of length d. Timestamp is a
+// duration, a percentage of d or a frame number like f:1234.
+func parseTimestamp(s string, d time.Duration, file string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "f:") {
+		n, err := strconv.Atoi(strings.TrimPrefix(s, "f:"))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid frame number %q", s)
+		}
+
+		fps, err := getFrameRate(file)
+		if err != nil {
+			return 0, err
+		}
+
+		t := secondsToDuration(float64(n) / fps)
+		if t >= d {
+			return 0, fmt.Errorf("frame %d is after the end of the video", n)
+		}
+		return t, nil
 	}
 
-	mid := fmt.Sprintf("%d", fileDuration/2)
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p < 0 || p > 100 {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
 
-	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
-		fmt.Printf("Total %d, mid %s", fileDuration, mid)
+		t := time.Duration(float64(d) * p / 100)
+		// There is no frame at the very end
+		if t >= d {
+			t = d - time.Second/10
+		}
+		return t, nil
 	}
 
-	return mid, nil
+	t, err := parseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if t >= d {
+		return 0, fmt.Errorf("timestamp %s is after the end of the video", s)
+	}
+	return t, nil
 }
 
-func createVideoSnapshot(timestamp string, file string, output string) {
-	cmd := exec.Command(app, "-hide_banner",
-		"-ss", timestamp,
-		"-i", file,
-		"-vframes", "1",
-		"-qscale:v", "1", // meaningless if output format is png
-		output)
+// createVideoSnapshots takes snapshots of file at timestamps, at every
+// interval, or count evenly spaced ones, in a single decode pass
+func createVideoSnapshots(file string, oPath string, img snapshotImage, at []string, every string, count int) {
+	d, err := getDuration(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to get duration of %s\t%s\n", file, err)
+		return
+	}
 
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	err := cmd.Run()
+	var timestamps []time.Duration
+	var expr string
+
+	switch {
+	case len(at) > 0:
+		for _, s := range at {
+			t, err := parseTimestamp(s, d, file)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			timestamps = append(timestamps, t)
+		}
+		expr = selectAt(timestamps)
+	case every != "":
+		interval, err := parseDuration(every)
+		if err != nil || interval <= 0 {
+			fmt.Fprintf(os.Stderr, "Invalid interval %v\n", every)
+			return
+		}
+		expr = selectEvery(interval)
+	default:
+		timestamps = evenlySpaced(d, count)
+		expr = selectAt(timestamps)
+	}
+
+	tmpDir, err := snapshotTempDir(getSnapshotFileName(oPath, file, "tmp", img.format))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir) // clean up
+
+	filters, err := img.filtersOf(file, 0)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	frames, err := extractFrames(file, nil, expr, filters, tmpDir, img.frameExt(), img.frameArgs())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	// --every is named by time of the frame, others by the timestamp asked
+	if timestamps == nil {
+		for _, f := range frames {
+			timestamps = append(timestamps, f.time)
+		}
+	}
+
+	for _, t := range timestamps {
+		f, ok := frameAt(frames, t)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "No frame at %ss of %s\n", formatSeconds(t), file)
+			continue
+		}
+
+		of := getSnapshotFileName(oPath, file, formatSeconds(t.Round(time.Millisecond)), img.format)
+		if err := img.save(f.file, of); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+
+		fmt.Printf("%s \t frame at %ss \t %s\n", file, formatSeconds(f.time), of)
+	}
+}
+
+// createVideoSnapshot writes frame of file at t to output and returns time
+// of the frame.
+//
+// It seeks to t with an input seek. If accurate is true, the input seek
+// lands accurateSeekMargin before t, and then an output seek decodes and
+// drops frames till t.
+func createVideoSnapshot(t time.Duration, file string, output string, img snapshotImage, accurate bool) (time.Duration, error) {
+	seek, skip := t, time.Duration(0)
+	if accurate {
+		seek = t - accurateSeekMargin
+		if seek < 0 {
+			seek = 0
+		}
+		skip = t - seek
+	}
+
+	f, err := img.filtersOf(file, seek)
+	if err != nil {
+		return 0, err
+	}
+	filters := append([]filtergraph.Filter{filtergraph.NewFilter("showinfo")}, f...)
+
+	a := []string{"-hide_banner", "-ss", formatSeconds(seek), "-i", file}
+	if accurate {
+		a = append(a, "-ss", formatSeconds(skip))
+	}
+	a = append(a, "-vframes", "1",
+		"-vf", filtergraph.Chain{Filters: filters}.String())
+	a = append(a, img.args()...)
+	a = append(a, output)
+
+	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+		fmt.Printf("Command is\n%s %v\n", app, a)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(app, a...)
+	cmd.Stderr = &stderr
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		fmt.Fprint(os.Stderr, stderr.String())
+		return 0, err
+	}
+
+	// Time of frames restarts from zero at the input seek point. Output seek
+	// drops frames after the filters, so frames before skip are logged too.
+	for _, e := range parseShowinfoTimes(stderr.Bytes()) {
+		if e >= skip-frameTolerance {
+			return seek + e, nil
+		}
 	}
+	return 0, fmt.Errorf("no frame at %ss of %s", formatSeconds(t), file)
 }
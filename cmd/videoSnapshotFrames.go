@@ -0,0 +1,180 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+const (
+	// frameTolerance is how much a frame can be before a timestamp and still
+	// be taken as the frame at it. Frame times are rounded by ffmpeg.
+	frameTolerance = time.Millisecond
+	// accurateSeekMargin is how far before a timestamp an accurate seek
+	// lands. Frames from there to the timestamp are decoded and dropped.
+	accurateSeekMargin = 5 * time.Second
+)
+
+// snapshotFrame is a frame written by extractFrames
+type snapshotFrame struct {
+	file string
+	// time is the presentation time of the frame
+	time time.Duration
+}
+
+// extractFrames writes frames of file picked by select expression to dir in
+// a single decode pass. filters run on the picked frames. It returns the
+// frames in order with their times.
+func extractFrames(file string, input []string, selectExpr string, filters []filtergraph.Filter, dir string, ext string, extra []string) ([]snapshotFrame, error) {
+	times, err := selectFrames(file, input, selectExpr, filters, dir, ext, extra)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []snapshotFrame
+	for i, t := range times {
+		f := frameFileName(dir, ext, i+1)
+		if _, err := os.Stat(f); err != nil {
+			break
+		}
+		frames = append(frames, snapshotFrame{file: f, time: t})
+	}
+
+	return frames, nil
+}
+
+// frameFileName returns name of ith image written by selectFrames
+func frameFileName(dir string, ext string, i int) string {
+	return filepath.Join(dir, fmt.Sprintf("frame-%05d.%s", i, ext))
+}
+
+// selectFrames runs filters on frames of file picked by select expression
+// and writes the images they make to dir. It returns times of the picked
+// frames. Filters like tile can make fewer images than picked frames.
+//
+// input are options of file like -ss and -t, that limit the part of it that
+// is decoded. extra are options of the images.
+func selectFrames(file string, input []string, selectExpr string, filters []filtergraph.Filter, dir string, ext string, extra []string) ([]time.Duration, error) {
+	vf := filtergraph.Chain{Filters: append([]filtergraph.Filter{
+		filtergraph.NewFilter("select").Arg(selectExpr),
+		filtergraph.NewFilter("showinfo"),
+	}, filters...)}.String()
+
+	a := append([]string{"-hide_banner"}, input...)
+	a = append(a, "-i", file, "-an", "-vf", vf, "-vsync", "vfr")
+	a = append(a, extra...)
+	a = append(a, filepath.Join(dir, "frame-%05d."+ext))
+
+	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+		fmt.Printf("Command is\n%s %v\n", app, a)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(app, a...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprint(os.Stderr, stderr.String())
+		return nil, err
+	}
+
+	return parseShowinfoTimes(stderr.Bytes()), nil
+}
+
+// parseShowinfoTimes returns pts_time of every frame logged by showinfo
+// filter. A line is like
+//
+//	[Parsed_showinfo_1 @ 0x7f] n:   0 pts:  12800 pts_time:1       ...
+func parseShowinfoTimes(log []byte) []time.Duration {
+	var times []time.Duration
+
+	scanner := bufio.NewScanner(bytes.NewReader(log))
+	for scanner.Scan() {
+		l := scanner.Text()
+		if !strings.Contains(l, "Parsed_showinfo") {
+			continue
+		}
+
+		i := strings.Index(l, " pts_time:")
+		if i < 0 {
+			continue
+		}
+
+		f := strings.Fields(l[i+len(" pts_time:"):])
+		if len(f) == 0 {
+			continue
+		}
+
+		s, err := strconv.ParseFloat(f[0], 64)
+		if err != nil {
+			continue
+		}
+		times = append(times, secondsToDuration(s))
+	}
+
+	return times
+}
+
+// selectAt returns select expression that picks the first frame at or after
+// every timestamp
+func selectAt(timestamps []time.Duration) string {
+	terms := make([]string, len(timestamps))
+	for i, t := range timestamps {
+		if t <= frameTolerance {
+			terms[i] = "eq(n,0)"
+		} else {
+			s := formatSeconds(t - frameTolerance)
+			terms[i] = fmt.Sprintf("gte(t,%s)*lt(prev_t,%s)", s, s)
+		}
+	}
+	return strings.Join(terms, "+")
+}
+
+// selectEvery returns select expression that picks the first frame and then
+// a frame every interval
+func selectEvery(interval time.Duration) string {
+	return fmt.Sprintf("isnan(prev_selected_t)+gte(t-prev_selected_t,%s)", formatSeconds(interval))
+}
+
+// frameAt returns the first frame at or after t
+func frameAt(frames []snapshotFrame, t time.Duration) (snapshotFrame, bool) {
+	for _, f := range frames {
+		if f.time >= t-frameTolerance {
+			return f, true
+		}
+	}
+	return snapshotFrame{}, false
+}
+
+// snapshotTempDir returns temporary directory for frames of output
+func snapshotTempDir(output string) (string, error) {
+	return ioutil.TempDir(filepath.Dir(output), "."+getFileNameWithoutExtension(output))
+}
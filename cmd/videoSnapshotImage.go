@@ -0,0 +1,205 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+// snapshotImage is format, size and quality of snapshots
+type snapshotImage struct {
+	format string
+	width  int // zero keeps aspect ratio
+	height int // zero keeps aspect ratio
+	// quality is from 1 to 100, higher is better. png ignores it.
+	quality int
+	overlay textOverlay
+}
+
+var (
+	snapshotFormats = []string{"png", "jpg", "webp", "avif"}
+	// imageEncoders are encoders of formats, png and jpg are always built in
+	imageEncoders = map[string]string{"png": "png", "jpg": "mjpeg", "webp": "libwebp", "avif": "libaom-av1"}
+	// defaultQuality is quality of a format when --quality is not set
+	defaultQuality = map[string]int{"png": 100, "jpg": 100, "webp": 90, "avif": 80}
+)
+
+// addSnapshotImageFlags adds flags read by newSnapshotImage to cmd
+func addSnapshotImageFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("format", "f", "png", "Output format. "+strings.Join(snapshotFormats, "|"))
+	cmd.Flags().Int("width", 0, "Width of snapshot. Default is width of video, 320 for frames of --contact-sheet and 160 for --sprite")
+	cmd.Flags().Int("height", 0, "Height of snapshot. Default keeps aspect ratio")
+	cmd.Flags().Int("quality", 0, "Quality from 1 to 100. Default is 100 for jpg, 90 for webp and 80 for avif")
+	addOverlayFlags(cmd)
+}
+
+// newSnapshotImage reads image flags of cmd and checks them against
+// encoders of local ffmpeg
+func newSnapshotImage(cmd *cobra.Command) (snapshotImage, error) {
+	var s snapshotImage
+
+	s.format, _ = cmd.Flags().GetString("format")
+	s.width, _ = cmd.Flags().GetInt("width")
+	s.height, _ = cmd.Flags().GetInt("height")
+	s.quality, _ = cmd.Flags().GetInt("quality")
+
+	if indexOf(snapshotFormats, s.format) < 0 {
+		return s, fmt.Errorf("unknown format %v, valid values are [%s]", s.format, strings.Join(snapshotFormats, "|"))
+	}
+
+	if s.width < 0 || s.height < 0 {
+		return s, fmt.Errorf("width and height must be positive")
+	}
+
+	if s.quality == 0 {
+		s.quality = defaultQuality[s.format]
+	}
+	if s.quality < 1 || s.quality > 100 {
+		return s, fmt.Errorf("quality must be from 1 to 100")
+	}
+
+	if e := imageEncoders[s.format]; !hasEncoder(e) {
+		return s, fmt.Errorf("ffmpeg has no %s encoder for %s", e, s.format)
+	}
+
+	var err error
+	s.overlay, err = newTextOverlay(cmd)
+	return s, err
+}
+
+// squarePixels returns filters that stretch anamorphic video to its display
+// aspect ratio
+func squarePixels() []filtergraph.Filter {
+	return []filtergraph.Filter{
+		filtergraph.NewFilter("scale").Set("w", "iw*sar").Set("h", "ih"),
+		filtergraph.NewFilter("setsar").Arg(1),
+	}
+}
+
+// filters returns filters that resize a frame to the snapshot size. Frame
+// is shown at its display aspect ratio, which is kept if only one of width
+// and height is set. If both are set frame is fit inside them.
+func (s snapshotImage) filters() []filtergraph.Filter {
+	f := squarePixels()
+
+	switch {
+	case s.width > 0 && s.height > 0:
+		f = append(f, filtergraph.NewFilter("scale").
+			Set("w", s.width).
+			Set("h", s.height).
+			Set("force_original_aspect_ratio", "decrease"))
+	case s.width > 0:
+		f = append(f, filtergraph.NewFilter("scale").Set("w", s.width).Set("h", -1))
+	case s.height > 0:
+		f = append(f, filtergraph.NewFilter("scale").Set("w", -1).Set("h", s.height))
+	}
+
+	return f
+}
+
+// filtersOf returns filters of snapshots of file, that is filters followed
+// by the overlay. Time of frames restarts from offset.
+func (s snapshotImage) filtersOf(file string, offset time.Duration) ([]filtergraph.Filter, error) {
+	f := s.filters()
+	if s.overlay.template == nil {
+		return f, nil
+	}
+
+	o, err := s.overlay.filter(file, offset)
+	if err != nil {
+		return nil, err
+	}
+	return append(f, o), nil
+}
+
+// args returns encoder options of the format. quality is mapped to the
+// scale of the encoder.
+func (s snapshotImage) args() []string {
+	// scale maps quality to an encoder scale from best to worst
+	scale := func(best, worst float64) string {
+		return fmt.Sprint(math.Round(best + (worst-best)*float64(100-s.quality)/99))
+	}
+
+	switch s.format {
+	case "jpg":
+		return []string{"-qmin", "1", "-qscale:v", scale(1, 31)}
+	case "webp":
+		return []string{"-c:v", "libwebp", "-quality", scale(100, 0)}
+	case "avif":
+		return []string{"-c:v", "libaom-av1", "-still-picture", "1", "-crf", scale(0, 63)}
+	}
+	return nil
+}
+
+// frameExt is extension of frames extracted from video. image2 muxer does
+// not write avif, so avif frames are extracted as png and converted by save.
+func (s snapshotImage) frameExt() string {
+	if s.format == "avif" {
+		return "png"
+	}
+	return s.format
+}
+
+// frameArgs returns encoder options of frames extracted from video
+func (s snapshotImage) frameArgs() []string {
+	if s.format == "avif" {
+		return nil
+	}
+	return s.args()
+}
+
+// saveOf is save that draws the overlay of frame at t of file on src. src
+// must be extracted with filters.
+func (s snapshotImage) saveOf(src string, dst string, file string, t time.Duration) error {
+	if s.overlay.template == nil {
+		return s.save(src, dst)
+	}
+
+	// src is a single image, so its time is zero
+	o, err := s.overlay.filter(file, t)
+	if err != nil {
+		return err
+	}
+
+	a := []string{"-hide_banner", "-v", "error", "-i", src, "-vf", filtergraph.Chain{Filters: []filtergraph.Filter{o}}.String()}
+	a = append(a, s.args()...)
+	a = append(a, dst)
+	return runFFmpeg(a)
+}
+
+// save writes frame src, extracted with frameExt, to dst in the snapshot
+// format
+func (s snapshotImage) save(src string, dst string) error {
+	if getFileExtension(src) == "."+s.format {
+		return copyFile(src, dst)
+	}
+
+	a := []string{"-hide_banner", "-v", "error", "-i", src}
+	a = append(a, s.args()...)
+	a = append(a, dst)
+	return runFFmpeg(a)
+}
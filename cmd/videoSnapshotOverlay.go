@@ -0,0 +1,180 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+// textOverlay is text drawn on snapshots. Text is a template of
+// overlayData.
+type textOverlay struct {
+	template *template.Template
+	font     string
+	size     int
+	color    string
+	box      bool
+	boxColor string
+	position string
+}
+
+// overlayData is what overlay template can show
+type overlayData struct {
+	// File is name of the video
+	File string
+	// Path is path of the video as given
+	Path string
+	// Timestamp is time of the frame as HH:MM:SS.mmm
+	Timestamp string
+}
+
+const (
+	// overlayMargin is distance of overlay from the edges of snapshot
+	overlayMargin = 10
+	// timestampMark stands for the timestamp until the text is escaped
+	timestampMark = "\x00timestamp\x00"
+)
+
+// overlayPositions are x and y expressions of drawtext for every position
+var overlayPositions = map[string][2]string{
+	"top-left":     {"MARGIN", "MARGIN"},
+	"top":          {"(w-tw)/2", "MARGIN"},
+	"top-right":    {"w-tw-MARGIN", "MARGIN"},
+	"left":         {"MARGIN", "(h-th)/2"},
+	"center":       {"(w-tw)/2", "(h-th)/2"},
+	"right":        {"w-tw-MARGIN", "(h-th)/2"},
+	"bottom-left":  {"MARGIN", "h-th-MARGIN"},
+	"bottom":       {"(w-tw)/2", "h-th-MARGIN"},
+	"bottom-right": {"w-tw-MARGIN", "h-th-MARGIN"},
+}
+
+var overlayPositionNames = []string{"top-left", "top", "top-right", "left", "center", "right",
+	"bottom-left", "bottom", "bottom-right"}
+
+// addOverlayFlags adds flags read by newTextOverlay to cmd
+func addOverlayFlags(cmd *cobra.Command) {
+	cmd.Flags().String("overlay", "", "Draw text on snapshot e.g. \"{{.File}} {{.Timestamp}}\"")
+	cmd.Flags().String("overlay-font", "", "Font name or path of font file of overlay. Default is the ffmpeg default")
+	cmd.Flags().Int("overlay-size", 24, "Font size of overlay")
+	cmd.Flags().String("overlay-color", "white", "Color of overlay text")
+	cmd.Flags().Bool("overlay-box", true, "Draw a box behind overlay text")
+	cmd.Flags().String("overlay-box-color", "black@0.5", "Color of overlay box")
+	cmd.Flags().String("overlay-position", "bottom-left", "Position of overlay. "+strings.Join(overlayPositionNames, "|"))
+}
+
+// newTextOverlay reads overlay flags of cmd. Template of overlay is nil if
+// --overlay is not set.
+func newTextOverlay(cmd *cobra.Command) (textOverlay, error) {
+	var o textOverlay
+
+	text, _ := cmd.Flags().GetString("overlay")
+	o.font, _ = cmd.Flags().GetString("overlay-font")
+	o.size, _ = cmd.Flags().GetInt("overlay-size")
+	o.color, _ = cmd.Flags().GetString("overlay-color")
+	o.box, _ = cmd.Flags().GetBool("overlay-box")
+	o.boxColor, _ = cmd.Flags().GetString("overlay-box-color")
+	o.position, _ = cmd.Flags().GetString("overlay-position")
+
+	if text == "" {
+		return o, nil
+	}
+
+	if _, ok := overlayPositions[o.position]; !ok {
+		return o, fmt.Errorf("unknown overlay position %v, valid values are [%s]", o.position, strings.Join(overlayPositionNames, "|"))
+	}
+
+	if o.size <= 0 {
+		return o, fmt.Errorf("overlay size must be positive")
+	}
+
+	if !hasFilter("drawtext") {
+		return o, fmt.Errorf("ffmpeg has no drawtext filter")
+	}
+
+	var err error
+	o.template, err = template.New("overlay").Parse(text)
+	if err != nil {
+		return o, fmt.Errorf("invalid overlay: %s", err)
+	}
+
+	return o, nil
+}
+
+// escapeDrawtext escapes text from % expansion of drawtext
+func escapeDrawtext(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`).Replace(s)
+}
+
+// text returns overlay text of file for drawtext. Time of frames restarts
+// from offset, so that is added to the timestamp.
+func (o textOverlay) text(file string, offset time.Duration) (string, error) {
+	var b strings.Builder
+	err := o.template.Execute(&b, overlayData{
+		File:      filepath.Base(file),
+		Path:      file,
+		Timestamp: timestampMark,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// drawtext writes time of every frame
+	pts := fmt.Sprintf("%%{pts:hms:%s}", formatSeconds(offset))
+	return strings.Replace(escapeDrawtext(b.String()), timestampMark, pts, -1), nil
+}
+
+// filter returns drawtext filter of overlay of file
+func (o textOverlay) filter(file string, offset time.Duration) (filtergraph.Filter, error) {
+	text, err := o.text(file, offset)
+	if err != nil {
+		return filtergraph.Filter{}, err
+	}
+
+	margin := fmt.Sprint(overlayMargin)
+	p := overlayPositions[o.position]
+
+	f := filtergraph.NewFilter("drawtext")
+	if _, err := os.Stat(o.font); err == nil {
+		f = f.Set("fontfile", o.font)
+	} else if o.font != "" {
+		f = f.Set("font", o.font)
+	}
+	f = f.Set("text", text).
+		Set("x", strings.Replace(p[0], "MARGIN", margin, -1)).
+		Set("y", strings.Replace(p[1], "MARGIN", margin, -1)).
+		Set("fontsize", o.size).
+		Set("fontcolor", o.color)
+	if o.box {
+		f = f.Set("box", 1).
+			Set("boxcolor", o.boxColor).
+			Set("boxborderw", o.size/4)
+	}
+
+	return f, nil
+}
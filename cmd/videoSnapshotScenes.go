@@ -0,0 +1,142 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// scene is a part of video between two cuts
+type scene struct {
+	Index    int     `json:"index"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Snapshot string  `json:"snapshot"`
+}
+
+// selectScenes returns select expression that picks the first frame and
+// every frame that differs from the previous one by more than threshold
+func selectScenes(threshold float64) string {
+	return fmt.Sprintf("eq(n,0)+gt(scene,%s)", strconv.FormatFloat(threshold, 'f', -1, 64))
+}
+
+// createSceneSnapshots takes snapshot at the start of every scene of file
+// and writes list of scenes in listFormat
+func createSceneSnapshots(file string, oPath string, img snapshotImage, threshold float64, listFormat string) {
+	d, err := getDuration(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to get duration of %s\t%s\n", file, err)
+		return
+	}
+
+	tmpDir, err := snapshotTempDir(getSnapshotFileName(oPath, file, "tmp", img.format))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir) // clean up
+
+	filters, err := img.filtersOf(file, 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	frames, err := extractFrames(file, nil, selectScenes(threshold), filters, tmpDir, img.frameExt(), img.frameArgs())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	var scenes []scene
+	for i, f := range frames {
+		end := d
+		if i+1 < len(frames) {
+			end = frames[i+1].time
+		}
+
+		of := getSnapshotFileName(oPath, file, formatSeconds(f.time.Round(time.Millisecond)), img.format)
+		if err := img.save(f.file, of); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+
+		scenes = append(scenes, scene{
+			Index:    i + 1,
+			Start:    f.time.Seconds(),
+			End:      end.Seconds(),
+			Snapshot: filepath.Base(of),
+		})
+
+		if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+			fmt.Printf("Scene %d \t %ss - %ss \t %s\n", i+1, formatSeconds(f.time), formatSeconds(end), of)
+		}
+	}
+
+	list := filepath.Join(oPath, fmt.Sprintf("%s-scenes.%s", getFileNameWithoutExtension(file), listFormat))
+	if err := writeSceneList(scenes, list, listFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("%s \t %d scenes \t %s\n", file, len(scenes), list)
+}
+
+// writeSceneList writes scenes to output as json or csv
+func writeSceneList(scenes []scene, output string, listFormat string) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	if listFormat == "csv" {
+		w := csv.NewWriter(f)
+		w.Write([]string{"index", "start", "end", "snapshot"})
+		for _, s := range scenes {
+			w.Write([]string{
+				strconv.Itoa(s.Index),
+				strconv.FormatFloat(s.Start, 'f', -1, 64),
+				strconv.FormatFloat(s.End, 'f', -1, 64),
+				s.Snapshot,
+			})
+		}
+		w.Flush()
+		err = w.Error()
+	} else {
+		e := json.NewEncoder(f)
+		e.SetIndent("", "  ")
+		if scenes == nil {
+			scenes = []scene{}
+		}
+		err = e.Encode(scenes)
+	}
+
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+const (
+	// sheetCellWidth is width of a frame in contact sheet, unless it is set
+	// by --width or --height
+	sheetCellWidth = 320
+	// sheetHeaderHeight is height of the header above the frames
+	sheetHeaderHeight = 64
+)
+
+// evenlySpaced returns n timestamps in the middle of n equal parts of d
+func evenlySpaced(d time.Duration, n int) []time.Duration {
+	t := make([]time.Duration, n)
+	for i := range t {
+		t[i] = d * time.Duration(2*i+1) / time.Duration(2*n)
+	}
+	return t
+}
+
+// formatClock formats duration as HH:MM:SS
+func formatClock(d time.Duration) string {
+	s := int(d.Round(time.Second) / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// sheetHeader returns the header text of contact sheet of file. It has the
+// file name in first line, and duration, resolution and codecs in second.
+func sheetHeader(file string, info mediaInfo) string {
+	details := []string{formatClock(info.duration())}
+
+	var codecs []string
+	if v, ok := info.video(); ok {
+		details = append(details, fmt.Sprintf("%dx%d", v.Width, v.Height))
+		codecs = append(codecs, v.CodecName)
+	}
+	if a, ok := info.audio(); ok {
+		codecs = append(codecs, a.CodecName)
+	}
+	if len(codecs) > 0 {
+		details = append(details, strings.Join(codecs, " / "))
+	}
+
+	return filepath.Base(file) + "\n" + strings.Join(details, " | ")
+}
+
+// sheetTimestamp returns drawtext filter that writes timestamp of a frame
+// of contact sheet
+func sheetTimestamp() filtergraph.Filter {
+	return filtergraph.NewFilter("drawtext").
+		Set("text", "%{pts:hms}").
+		Set("x", "w-tw-8").
+		Set("y", "h-th-8").
+		Set("fontsize", 16).
+		Set("fontcolor", "white").
+		Set("box", 1).
+		Set("boxcolor", "black@0.6").
+		Set("boxborderw", 4)
+}
+
+// sheetCell returns filters that resize a frame of contact sheet. Frames are
+// sized by --width and --height like other snapshots, or are sheetCellWidth
+// wide.
+func (s snapshotImage) sheetCell() []filtergraph.Filter {
+	if s.width > 0 || s.height > 0 {
+		return s.filters()
+	}
+	return append(squarePixels(), filtergraph.NewFilter("scale").Set("w", sheetCellWidth).Set("h", -2))
+}
+
+// sheetFilters returns filters that turn columns x rows frames, resized by
+// cell, into a contact sheet. Every frame gets label and the sheet gets
+// header.
+func sheetFilters(columns int, rows int, header string, cell []filtergraph.Filter, label filtergraph.Filter) []filtergraph.Filter {
+	return append(cell,
+		label,
+		filtergraph.NewFilter("tile").
+			Arg(fmt.Sprintf("%dx%d", columns, rows)).
+			Set("padding", 4).
+			Set("margin", 4),
+		filtergraph.NewFilter("pad").
+			Set("w", "iw").
+			Set("h", fmt.Sprintf("ih+%d", sheetHeaderHeight)).
+			Set("y", sheetHeaderHeight),
+		filtergraph.NewFilter("drawtext").
+			Set("text", header).
+			Set("expansion", "none").
+			Set("x", 8).
+			Set("y", 12).
+			Set("fontsize", 18).
+			Set("line_spacing", 8).
+			Set("fontcolor", "white"),
+	)
+}
+
+// createContactSheet writes a grid of evenly spaced frames of file as a
+// single image. grid is columns x rows e.g. 4x4.
+func createContactSheet(file string, oPath string, img snapshotImage, grid string) {
+	columns, rows, err := parseSize(grid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid contact sheet %q, use columns x rows e.g. 4x4\n", grid)
+		return
+	}
+
+	info, err := probeMedia(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to probe %s\t%s\n", file, err)
+		return
+	}
+
+	d := info.duration()
+	if d <= 0 {
+		fmt.Fprintf(os.Stderr, "Unable to get duration of %s\n", file)
+		return
+	}
+
+	of := getSnapshotFileName(oPath, file, "sheet", img.format)
+	tmpDir, err := snapshotTempDir(of)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir) // clean up
+
+	label := sheetTimestamp()
+	if img.overlay.template != nil {
+		label, err = img.overlay.filter(file, 0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+	}
+
+	expr := selectAt(evenlySpaced(d, columns*rows))
+	filters := sheetFilters(columns, rows, sheetHeader(file, info), img.sheetCell(), label)
+	frames, err := extractFrames(file, nil, expr, filters, tmpDir, img.frameExt(), img.frameArgs())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	// tile filter turns all of the frames into the first one
+	if len(frames) == 0 {
+		fmt.Fprintf(os.Stderr, "No frames in %s\n", file)
+		return
+	}
+
+	if err := img.save(frames[0].file, of); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+		fmt.Printf("Contact sheet %s\n", of)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/png" // decode candidate frames
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	// smartCandidates is the number of frames compared by --smart
+	smartCandidates = 15
+
+	// Weights of the scores of a frame. They add up to 1.
+	brightnessWeight     = 0.2
+	contrastWeight       = 0.25
+	sharpnessWeight      = 0.3
+	representativeWeight = 0.25
+)
+
+// frameScore explains how good a frame is as a thumbnail. Every score is
+// between 0 and 1, and 1 is best.
+type frameScore struct {
+	Time time.Duration `json:"-"`
+	// Seconds is Time for JSON output
+	Seconds float64 `json:"time"`
+	// Brightness is best for mid gray and worst for black or white
+	Brightness float64 `json:"brightness"`
+	// Contrast is standard deviation of luma
+	Contrast float64 `json:"contrast"`
+	// Sharpness is variance of Laplacian relative to the sharpest candidate
+	Sharpness float64 `json:"sharpness"`
+	// Representative is how close the histogram of the frame is to the
+	// average histogram of candidates. thumbnail filter of ffmpeg uses
+	// the same measure.
+	Representative float64 `json:"representative"`
+	Score          float64 `json:"score"`
+
+	file      string
+	laplacian float64
+	histogram [256]float64
+}
+
+// lumaStats returns mean and standard deviation of luma, variance of its
+// Laplacian and its normalized histogram. Luma is between 0 and 1.
+func lumaStats(img image.Image) (mean float64, stddev float64, laplacian float64, histogram [256]float64) {
+	b := img.Bounds()
+	gray := image.NewGray(b)
+	draw.Draw(gray, b, img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return
+	}
+
+	var sum, sumSq float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := gray.Pix[y*gray.Stride+x]
+			histogram[p]++
+			sum += float64(p)
+			sumSq += float64(p) * float64(p)
+		}
+	}
+
+	n := float64(w * h)
+	mean = sum / n
+	stddev = math.Sqrt(math.Max(sumSq/n-mean*mean, 0)) / 255
+	mean /= 255
+	for i := range histogram {
+		histogram[i] /= n
+	}
+
+	var lSum, lSumSq, lN float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*gray.Stride + x
+			l := 4*float64(gray.Pix[i]) -
+				float64(gray.Pix[i-1]) - float64(gray.Pix[i+1]) -
+				float64(gray.Pix[i-gray.Stride]) - float64(gray.Pix[i+gray.Stride])
+			lSum += l
+			lSumSq += l * l
+			lN++
+		}
+	}
+	if lN > 0 {
+		laplacian = lSumSq/lN - (lSum/lN)*(lSum/lN)
+	}
+
+	return
+}
+
+// scoreFrames scores every frame. Frames must be png.
+func scoreFrames(frames []snapshotFrame) ([]frameScore, error) {
+	scores := make([]frameScore, len(frames))
+	var average [256]float64
+	var maxLaplacian float64
+
+	for i, f := range frames {
+		r, err := os.Open(f.file)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		mean, stddev, laplacian, histogram := lumaStats(img)
+		scores[i] = frameScore{
+			Time:       f.time,
+			Seconds:    f.time.Seconds(),
+			Brightness: math.Max(1-math.Abs(mean-0.5)*2, 0),
+			Contrast:   math.Min(stddev/0.25, 1),
+			file:       f.file,
+			laplacian:  laplacian,
+			histogram:  histogram,
+		}
+
+		for j := range average {
+			average[j] += histogram[j] / float64(len(frames))
+		}
+		maxLaplacian = math.Max(maxLaplacian, laplacian)
+	}
+
+	errs := make([]float64, len(scores))
+	var maxErr float64
+	for i, s := range scores {
+		for j := range average {
+			d := s.histogram[j] - average[j]
+			errs[i] += d * d
+		}
+		maxErr = math.Max(maxErr, errs[i])
+	}
+
+	for i := range scores {
+		s := &scores[i]
+		s.Representative = 1
+		if maxErr > 0 {
+			s.Representative = 1 - errs[i]/maxErr
+		}
+		if maxLaplacian > 0 {
+			s.Sharpness = s.laplacian / maxLaplacian
+		}
+		s.Score = brightnessWeight*s.Brightness +
+			contrastWeight*s.Contrast +
+			sharpnessWeight*s.Sharpness +
+			representativeWeight*s.Representative
+	}
+
+	return scores, nil
+}
+
+// createSmartSnapshot compares frames within window of position and saves
+// the best one. If asJSON is true scores of all candidates are printed as
+// JSON. It returns path of the snapshot, or empty string if it fails.
+func createSmartSnapshot(file string, oPath string, img snapshotImage, position time.Duration, window time.Duration, asJSON bool) string {
+	d, err := getDuration(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to get duration of %s\t%s\n", file, err)
+		return ""
+	}
+
+	start, end := position-window, position+window
+	if start < 0 {
+		start = 0
+	}
+	// There is no frame at the very end
+	if last := d - time.Second/10; end > last {
+		end = last
+	}
+	if end < start {
+		end = start
+	}
+
+	// Only the window is decoded. Time of frames restarts from zero at
+	// start, so candidates are picked by time from start.
+	timestamps := make([]time.Duration, smartCandidates)
+	for i := range timestamps {
+		timestamps[i] = (end - start) * time.Duration(i) / time.Duration(smartCandidates-1)
+	}
+	// A second more is read, so the frame at end is not cut off
+	input := []string{"-ss", formatSeconds(start), "-t", formatSeconds(end - start + time.Second)}
+
+	tmpDir, err := snapshotTempDir(getSnapshotFileName(oPath, file, "tmp", img.format))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ""
+	}
+	defer os.RemoveAll(tmpDir) // clean up
+
+	// Overlay would change the scores, so it is drawn on the chosen frame
+	frames, err := extractFrames(file, input, selectAt(timestamps), img.filters(), tmpDir, "png", nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ""
+	}
+	for i := range frames {
+		frames[i].time += start
+	}
+	if len(frames) == 0 {
+		fmt.Fprintf(os.Stderr, "No frames in %s\n", file)
+		return ""
+	}
+
+	scores, err := scoreFrames(frames)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ""
+	}
+
+	best := scores[0]
+	for _, s := range scores[1:] {
+		if s.Score > best.Score {
+			best = s
+		}
+	}
+
+	of := getSnapshotFileName(oPath, file, formatSeconds(best.Time.Round(time.Millisecond)), img.format)
+	if err := img.saveOf(best.file, of, file, best.Time); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ""
+	}
+
+	if asJSON {
+		out, _ := json.MarshalIndent(struct {
+			File       string       `json:"file"`
+			Output     string       `json:"output"`
+			Chosen     frameScore   `json:"chosen"`
+			Candidates []frameScore `json:"candidates"`
+		}{file, of, best, scores}, "", "  ")
+		fmt.Println(string(out))
+	} else if v, _ := rootCmd.Flags().GetBool("verbose"); v {
+		fmt.Println("time \t brightness \t contrast \t sharpness \t representative \t score")
+		for _, s := range scores {
+			mark := ""
+			if s.file == best.file {
+				mark = "\t chosen"
+			}
+			fmt.Printf("%ss \t %.3f \t %.3f \t %.3f \t %.3f \t %.3f%s\n", formatSeconds(s.Time),
+				s.Brightness, s.Contrast, s.Sharpness, s.Representative, s.Score, mark)
+		}
+		fmt.Printf("Snapshot %s\n", of)
+	}
+
+	return of
+}
@@ -0,0 +1,184 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/talha131/bmtool/filtergraph"
+)
+
+const (
+	// spriteWidth is width of a thumbnail in sprite if --width is not set
+	spriteWidth = 160
+	// spriteQuality is jpeg quality of sprites. Sprites are for web player,
+	// so they are kept small.
+	spriteQuality = "5"
+)
+
+// sprite is a set of sprite pages of thumbnails taken every interval
+type sprite struct {
+	interval time.Duration
+	columns  int
+	rows     int
+	width    int
+	height   int
+}
+
+// newSprite returns sprite of thumbnails of video stream v. tile is columns
+// x rows e.g. 10x10. Height of thumbnails keeps display aspect ratio of v.
+func newSprite(interval string, tile string, width int, v mediaStream) (sprite, error) {
+	var s sprite
+
+	i, err := parseDuration(interval)
+	if err != nil || i <= 0 {
+		return s, fmt.Errorf("invalid interval %q", interval)
+	}
+
+	columns, rows, err := parseSize(tile)
+	if err != nil {
+		return s, fmt.Errorf("invalid tile %q, use columns x rows e.g. 10x10", tile)
+	}
+
+	if width <= 0 {
+		width = spriteWidth
+	}
+
+	w, h := v.displaySize()
+	if w <= 0 || h <= 0 {
+		return s, fmt.Errorf("unknown size of video")
+	}
+	// Even height is needed by most encoders
+	height := int(math.Round(float64(width)*float64(h)/float64(w)/2)) * 2
+
+	return sprite{i, columns, rows, width, height}, nil
+}
+
+// filters returns filters that scale the picked frames and tile them in
+// pages
+func (s sprite) filters() []filtergraph.Filter {
+	return []filtergraph.Filter{
+		filtergraph.NewFilter("scale").Set("w", s.width).Set("h", s.height),
+		filtergraph.NewFilter("setsar").Arg(1),
+		filtergraph.NewFilter("tile").Arg(fmt.Sprintf("%dx%d", s.columns, s.rows)),
+	}
+}
+
+// cell returns page and position of ith thumbnail. Page starts from 1.
+func (s sprite) cell(i int) (page int, x int, y int) {
+	perPage := s.columns * s.rows
+	j := i % perPage
+	return i/perPage + 1, j % s.columns * s.width, j / s.columns * s.height
+}
+
+// formatVTTTime formats duration as HH:MM:SS.mmm
+func formatVTTTime(d time.Duration) string {
+	ms := int64(d.Round(time.Millisecond) / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// vtt returns WebVTT that maps time of every thumbnail to its place in
+// pages. times are times of the thumbnails and d is duration of video.
+func (s sprite) vtt(times []time.Duration, d time.Duration, pages []string) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	for i, t := range times {
+		end := d
+		if i+1 < len(times) {
+			end = times[i+1]
+		}
+
+		page, x, y := s.cell(i)
+		if page > len(pages) {
+			break
+		}
+
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatVTTTime(t), formatVTTTime(end), pages[page-1], x, y, s.width, s.height)
+	}
+
+	return b.String()
+}
+
+// createSprite writes sprite pages of thumbnails of file and WebVTT that
+// maps them to time
+func createSprite(file string, oPath string, interval string, tile string, width int) {
+	info, err := probeMedia(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to probe %s\t%s\n", file, err)
+		return
+	}
+
+	v, ok := info.video()
+	if !ok {
+		fmt.Fprintf(os.Stderr, "No video stream in %s\n", file)
+		return
+	}
+
+	s, err := newSprite(interval, tile, width, v)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	tmpDir, err := snapshotTempDir(getSnapshotFileName(oPath, file, "tmp", "jpg"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir) // clean up
+
+	times, err := selectFrames(file, nil, selectEvery(s.interval), s.filters(), tmpDir, "jpg", []string{"-qscale:v", spriteQuality})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	var pages []string
+	for i := 1; ; i++ {
+		f := frameFileName(tmpDir, "jpg", i)
+		if _, err := os.Stat(f); err != nil {
+			break
+		}
+
+		of := getSnapshotFileName(oPath, file, fmt.Sprintf("sprite-%03d", i), "jpg")
+		if err := os.Rename(f, of); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		pages = append(pages, filepath.Base(of))
+	}
+
+	vtt := getSnapshotFileName(oPath, file, "sprite", "vtt")
+	if err := ioutil.WriteFile(vtt, []byte(s.vtt(times, info.duration(), pages)), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+
+	fmt.Printf("%s \t %d thumbnails \t %d pages \t %s\n", file, len(times), len(pages), vtt)
+}
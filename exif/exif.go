@@ -0,0 +1,515 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package exif reads date and time of EXIF metadata of JPEG, TIFF and HEIC
+// files.
+//
+// Only the tags needed to tell when a photo was taken are read, so the
+// package does not need to understand the rest of the metadata.
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoExif is returned when a file has no EXIF metadata or no date in it
+var ErrNoExif = errors.New("exif: no exif data")
+
+// Layout is the layout of EXIF date and time
+const Layout = "2006:01:02 15:04:05"
+
+// Tags used to find date and time
+const (
+	tagExifIFD            = 0x8769
+	tagDateTime           = 0x0132
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	typeASCII             = 2
+	typeLong              = 4
+)
+
+// Exif is date and time tags of a file. A tag is empty if the file does not
+// have it.
+type Exif struct {
+	// DateTimeOriginal is when the photo was taken as local time of the
+	// camera, in Layout
+	DateTimeOriginal string
+	// OffsetTimeOriginal is offset of DateTimeOriginal from UTC e.g. +05:00
+	OffsetTimeOriginal string
+	// DateTime is when the file was last changed, in Layout
+	DateTime string
+}
+
+// ReadFile reads EXIF of file name
+func ReadFile(name string) (*Exif, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return Read(f, fi.Size())
+}
+
+// Read reads EXIF of a JPEG, TIFF or HEIC file of size bytes
+func Read(r io.ReaderAt, size int64) (*Exif, error) {
+	head := make([]byte, 12)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, ErrNoExif
+	}
+
+	switch {
+	case head[0] == 0xFF && head[1] == 0xD8:
+		return readJPEG(r, size)
+	case string(head[:4]) == "II*\x00" || string(head[:4]) == "MM\x00*":
+		return readTIFF(io.NewSectionReader(r, 0, size))
+	case string(head[4:8]) == "ftyp":
+		return readHEIF(r, size)
+	}
+
+	return nil, ErrNoExif
+}
+
+// readJPEG finds EXIF in APP1 segment of JPEG
+func readJPEG(r io.ReaderAt, size int64) (*Exif, error) {
+	marker := make([]byte, 4)
+	for off := int64(2); off+4 <= size; {
+		if _, err := r.ReadAt(marker, off); err != nil {
+			return nil, ErrNoExif
+		}
+		if marker[0] != 0xFF {
+			return nil, ErrNoExif
+		}
+
+		// Start of scan or end of image, there is no metadata after them
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, ErrNoExif
+		}
+
+		length := int64(binary.BigEndian.Uint16(marker[2:]))
+		if marker[1] == 0xE1 && length > 8 {
+			id := make([]byte, 6)
+			if _, err := r.ReadAt(id, off+4); err == nil && string(id) == "Exif\x00\x00" {
+				return readTIFF(io.NewSectionReader(r, off+10, length-8))
+			}
+		}
+
+		off += 2 + length
+	}
+
+	return nil, ErrNoExif
+}
+
+// box is an ISO base media file format box
+type box struct {
+	kind string
+	// offset and size of data of the box
+	offset int64
+	size   int64
+}
+
+// readBoxes returns boxes within offset and offset + size
+func readBoxes(r io.ReaderAt, offset int64, size int64) ([]box, error) {
+	var boxes []box
+	header := make([]byte, 16)
+
+	for end := offset + size; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+
+		n := int64(binary.BigEndian.Uint32(header))
+		kind := string(header[4:8])
+		headerSize := int64(8)
+
+		switch n {
+		case 0:
+			n = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:], offset+8); err != nil {
+				return nil, err
+			}
+			n = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+
+		if n < headerSize || offset+n > end {
+			return nil, fmt.Errorf("exif: invalid %q box", kind)
+		}
+
+		boxes = append(boxes, box{kind, offset + headerSize, n - headerSize})
+		offset += n
+	}
+
+	return boxes, nil
+}
+
+func findBox(boxes []box, kind string) (box, bool) {
+	for _, b := range boxes {
+		if b.kind == kind {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// readHEIF finds EXIF item of HEIC file. Items are listed in iinf box and
+// located by iloc box, both of them are in meta box.
+func readHEIF(r io.ReaderAt, size int64) (*Exif, error) {
+	top, err := readBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, ok := findBox(top, "meta")
+	if !ok || meta.size < 4 {
+		return nil, ErrNoExif
+	}
+
+	// meta is a full box, its children come after version and flags
+	children, err := readBoxes(r, meta.offset+4, meta.size-4)
+	if err != nil {
+		return nil, err
+	}
+
+	iinf, okInf := findBox(children, "iinf")
+	iloc, okLoc := findBox(children, "iloc")
+	if !okInf || !okLoc {
+		return nil, ErrNoExif
+	}
+
+	id, err := findExifItem(r, iinf)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, length, err := locateItem(r, iloc, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Item starts with offset of TIFF header from the end of the offset
+	p := make([]byte, 4)
+	if length < 4 {
+		return nil, ErrNoExif
+	}
+	if _, err := r.ReadAt(p, offset); err != nil {
+		return nil, err
+	}
+	skip := 4 + int64(binary.BigEndian.Uint32(p))
+	if skip >= length {
+		return nil, ErrNoExif
+	}
+
+	return readTIFF(io.NewSectionReader(r, offset+skip, length-skip))
+}
+
+// reader reads big endian numbers of a box
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uint(n int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if n > len(r.data) {
+		r.err = errors.New("exif: box is too short")
+		return 0
+	}
+
+	var v uint64
+	for _, b := range r.data[:n] {
+		v = v<<8 | uint64(b)
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func readBox(r io.ReaderAt, b box) (*reader, error) {
+	// Metadata boxes are small, anything big is not a valid file
+	if b.size > 1<<20 {
+		return nil, fmt.Errorf("exif: %q box is too big", b.kind)
+	}
+
+	data := make([]byte, b.size)
+	if _, err := r.ReadAt(data, b.offset); err != nil {
+		return nil, err
+	}
+	return &reader{data: data}, nil
+}
+
+// findExifItem returns id of Exif item in iinf box
+func findExifItem(r io.ReaderAt, iinf box) (uint64, error) {
+	br, err := readBox(r, iinf)
+	if err != nil {
+		return 0, err
+	}
+
+	version := br.uint(1)
+	br.uint(3) // flags
+	if version == 0 {
+		br.uint(2) // entry count
+	} else {
+		br.uint(4)
+	}
+	if br.err != nil {
+		return 0, br.err
+	}
+
+	start := iinf.offset + iinf.size - int64(len(br.data))
+	entries, err := readBoxes(r, start, int64(len(br.data)))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		if e.kind != "infe" {
+			continue
+		}
+
+		er, err := readBox(r, e)
+		if err != nil {
+			return 0, err
+		}
+
+		v := er.uint(1)
+		er.uint(3) // flags
+		if v < 2 {
+			continue
+		}
+
+		var id uint64
+		if v == 2 {
+			id = er.uint(2)
+		} else {
+			id = er.uint(4)
+		}
+		er.uint(2) // protection index
+		kind := er.data
+		if er.err == nil && len(kind) >= 4 && string(kind[:4]) == "Exif" {
+			return id, nil
+		}
+	}
+
+	return 0, ErrNoExif
+}
+
+// locateItem returns offset and length of the first extent of item id in
+// iloc box
+func locateItem(r io.ReaderAt, iloc box, id uint64) (int64, int64, error) {
+	br, err := readBox(r, iloc)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	version := br.uint(1)
+	br.uint(3) // flags
+	sizes := br.uint(2)
+	offsetSize := int(sizes >> 12 & 0xF)
+	lengthSize := int(sizes >> 8 & 0xF)
+	baseSize := int(sizes >> 4 & 0xF)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xF)
+	}
+
+	var count uint64
+	if version < 2 {
+		count = br.uint(2)
+	} else {
+		count = br.uint(4)
+	}
+
+	for i := uint64(0); i < count && br.err == nil; i++ {
+		var itemID uint64
+		if version < 2 {
+			itemID = br.uint(2)
+		} else {
+			itemID = br.uint(4)
+		}
+
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = br.uint(2) & 0xF
+		}
+		br.uint(2) // data reference index
+		base := br.uint(baseSize)
+
+		extents := br.uint(2)
+		for j := uint64(0); j < extents && br.err == nil; j++ {
+			br.uint(indexSize)
+			offset := br.uint(offsetSize)
+			length := br.uint(lengthSize)
+
+			// Only items in the file itself are read
+			if itemID == id && j == 0 && method == 0 && br.err == nil {
+				return int64(base + offset), int64(length), nil
+			}
+		}
+	}
+
+	if br.err != nil {
+		return 0, 0, br.err
+	}
+	return 0, 0, ErrNoExif
+}
+
+// readTIFF reads tags of IFD0 and EXIF IFD of TIFF structure in r
+func readTIFF(r *io.SectionReader) (*Exif, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, ErrNoExif
+	}
+
+	var order binary.ByteOrder
+	switch string(header[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, ErrNoExif
+	}
+
+	t := tiff{r, order}
+	e := &Exif{}
+
+	ifd0, err := t.entries(int64(order.Uint32(header[4:])))
+	if err != nil {
+		return nil, err
+	}
+
+	e.DateTime = t.ascii(ifd0[tagDateTime])
+
+	if p, ok := ifd0[tagExifIFD]; ok && p.kind == typeLong {
+		exifIFD, err := t.entries(int64(order.Uint32(p.value[:])))
+		if err != nil {
+			return nil, err
+		}
+		e.DateTimeOriginal = t.ascii(exifIFD[tagDateTimeOriginal])
+		e.OffsetTimeOriginal = t.ascii(exifIFD[tagOffsetTimeOriginal])
+	}
+
+	if e.DateTimeOriginal == "" && e.DateTime == "" {
+		return nil, ErrNoExif
+	}
+	return e, nil
+}
+
+// tiff reads IFDs of TIFF structure
+type tiff struct {
+	r     *io.SectionReader
+	order binary.ByteOrder
+}
+
+// entry is an IFD entry. value is the value or offset of the value if it
+// does not fit in four bytes.
+type entry struct {
+	kind  uint16
+	count uint32
+	value [4]byte
+}
+
+// entries returns entries of IFD at offset by their tags
+func (t tiff) entries(offset int64) (map[uint16]entry, error) {
+	n := make([]byte, 2)
+	if _, err := t.r.ReadAt(n, offset); err != nil {
+		return nil, ErrNoExif
+	}
+
+	count := int64(t.order.Uint16(n))
+	data := make([]byte, count*12)
+	if _, err := t.r.ReadAt(data, offset+2); err != nil {
+		return nil, ErrNoExif
+	}
+
+	entries := make(map[uint16]entry, count)
+	for i := int64(0); i < count; i++ {
+		d := data[i*12:]
+		var e entry
+		e.kind = t.order.Uint16(d[2:])
+		e.count = t.order.Uint32(d[4:])
+		copy(e.value[:], d[8:12])
+		entries[t.order.Uint16(d)] = e
+	}
+
+	return entries, nil
+}
+
+// ascii returns value of an ASCII entry, or empty string
+func (t tiff) ascii(e entry) string {
+	if e.kind != typeASCII || e.count == 0 {
+		return ""
+	}
+
+	var s []byte
+	if e.count <= 4 {
+		s = e.value[:e.count]
+	} else {
+		// Dates are short, anything long is not a date
+		if e.count > 64 {
+			return ""
+		}
+		s = make([]byte, e.count)
+		if _, err := t.r.ReadAt(s, int64(t.order.Uint32(e.value[:]))); err != nil {
+			return ""
+		}
+	}
+
+	if i := bytes.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(string(s))
+}
+
+// Time returns DateTimeOriginal, or DateTime if the photo has no original
+// time. Time is in OffsetTimeOriginal if the photo has it, otherwise it is
+// taken as time in loc.
+func (e *Exif) Time(loc *time.Location) (time.Time, error) {
+	s := e.DateTimeOriginal
+	if s == "" {
+		s = e.DateTime
+	} else if e.OffsetTimeOriginal != "" {
+		t, err := time.Parse(Layout+"-07:00", s+e.OffsetTimeOriginal)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	t, err := time.ParseInLocation(Layout, s, loc)
+	if err != nil {
+		return t, fmt.Errorf("exif: invalid date %q", s)
+	}
+	return t, nil
+}
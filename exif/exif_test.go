@@ -0,0 +1,337 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// tag is an ASCII tag of a test TIFF
+type tag struct {
+	id    uint16
+	value string
+}
+
+// buildTIFF returns TIFF structure with ifd0 tags, and EXIF IFD with
+// exifTags if there are any
+func buildTIFF(order binary.ByteOrder, ifd0 []tag, exifTags []tag) []byte {
+	ifdSize := func(n int) int { return 2 + n*12 + 4 }
+
+	n0 := len(ifd0)
+	if len(exifTags) > 0 {
+		n0++
+	}
+	exifOffset := 8 + ifdSize(n0)
+	data := exifOffset
+	if len(exifTags) > 0 {
+		data += ifdSize(len(exifTags))
+	}
+
+	var values []byte
+	entries := func(tags []tag) []byte {
+		var b []byte
+		for _, t := range tags {
+			e := make([]byte, 12)
+			s := append([]byte(t.value), 0)
+			order.PutUint16(e, t.id)
+			order.PutUint16(e[2:], typeASCII)
+			order.PutUint32(e[4:], uint32(len(s)))
+			if len(s) <= 4 {
+				copy(e[8:], s)
+			} else {
+				order.PutUint32(e[8:], uint32(data+len(values)))
+				values = append(values, s...)
+			}
+			b = append(b, e...)
+		}
+		return b
+	}
+
+	b := make([]byte, 8)
+	if order == binary.LittleEndian {
+		copy(b, "II*\x00")
+	} else {
+		copy(b, "MM\x00*")
+	}
+	order.PutUint32(b[4:], 8)
+
+	n := make([]byte, 2)
+	order.PutUint16(n, uint16(n0))
+	b = append(b, n...)
+	b = append(b, entries(ifd0)...)
+	if len(exifTags) > 0 {
+		e := make([]byte, 12)
+		order.PutUint16(e, tagExifIFD)
+		order.PutUint16(e[2:], typeLong)
+		order.PutUint32(e[4:], 1)
+		order.PutUint32(e[8:], uint32(exifOffset))
+		b = append(b, e...)
+	}
+	b = append(b, 0, 0, 0, 0)
+
+	if len(exifTags) > 0 {
+		order.PutUint16(n, uint16(len(exifTags)))
+		b = append(b, n...)
+		b = append(b, entries(exifTags)...)
+		b = append(b, 0, 0, 0, 0)
+	}
+
+	return append(b, values...)
+}
+
+// buildJPEG returns JPEG with a JFIF segment and an APP1 segment of tiff
+func buildJPEG(tiff []byte) []byte {
+	b := []byte{0xFF, 0xD8}
+	b = append(b, 0xFF, 0xE0, 0x00, 0x10)
+	b = append(b, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")...)
+	if tiff != nil {
+		b = append(b, 0xFF, 0xE1)
+		b = append(b, byte((len(tiff)+8)>>8), byte(len(tiff)+8))
+		b = append(b, []byte("Exif\x00\x00")...)
+		b = append(b, tiff...)
+	}
+	return append(b, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9)
+}
+
+// buildBox returns ISO base media file format box
+func buildBox(kind string, data ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], kind)
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// buildHEIC returns HEIC with an image item and, if tiff is not nil, an
+// Exif item of tiff in mdat box
+func buildHEIC(tiff []byte) []byte {
+	u16 := func(v int) []byte { return []byte{byte(v >> 8), byte(v)} }
+	u32 := func(v int) []byte { return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)} }
+	infe := func(id int, kind string) []byte {
+		return buildBox("infe", []byte{2, 0, 0, 0}, u16(id), u16(0), []byte(kind), []byte{0})
+	}
+
+	// Exif item starts with offset of TIFF header after the offset
+	item := append(append(u32(6), []byte("Exif\x00\x00")...), tiff...)
+
+	ftyp := buildBox("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+	hdlr := buildBox("hdlr", make([]byte, 8), []byte("pict"), make([]byte, 13))
+
+	entries := [][]byte{infe(1, "hvc1")}
+	if tiff != nil {
+		entries = append(entries, infe(2, "Exif"))
+	}
+	iinf := buildBox("iinf", append([]byte{0, 0, 0, 0}, u16(len(entries))...), bytes.Join(entries, nil))
+
+	// iloc is the same size either way, so offset of mdat is known before it
+	ilocSize := 8 + 4 + 2 + 2 + 2*(2+2+2+4+4)
+	metaSize := 8 + 4 + len(hdlr) + len(iinf) + ilocSize
+	data := len(ftyp) + metaSize + 8
+
+	iloc := buildBox("iloc", []byte{0, 0, 0, 0}, []byte{0x44, 0x00}, u16(2),
+		u16(1), u16(0), u16(1), u32(data+len(item)), u32(0),
+		u16(2), u16(0), u16(1), u32(data), u32(len(item)))
+	meta := buildBox("meta", []byte{0, 0, 0, 0}, hdlr, iinf, iloc)
+
+	return bytes.Join([][]byte{ftyp, meta, buildBox("mdat", item)}, nil)
+}
+
+const (
+	original = "2019:05:03 10:20:30"
+	modified = "2020:01:02 03:04:05"
+	offset   = "+05:00"
+)
+
+var (
+	allTags = []tag{{tagDateTime, modified}}
+	exifAll = []tag{{tagDateTimeOriginal, original}, {tagOffsetTimeOriginal, offset}}
+	full    = &Exif{DateTimeOriginal: original, OffsetTimeOriginal: offset, DateTime: modified}
+)
+
+func TestRead(t *testing.T) {
+	tiffII := buildTIFF(binary.LittleEndian, allTags, exifAll)
+	tiffMM := buildTIFF(binary.BigEndian, allTags, exifAll)
+
+	// IFD0 says it has 0xFFFF entries, which are past the end
+	manyEntries := buildTIFF(binary.BigEndian, allTags, nil)
+	manyEntries[8], manyEntries[9] = 0xFF, 0xFF
+
+	// IFD0 offset is past the end
+	farIFD := buildTIFF(binary.LittleEndian, allTags, nil)
+	binary.LittleEndian.PutUint32(farIFD[4:], 1<<20)
+
+	// ftyp box is larger than the file
+	badBox := buildHEIC(tiffMM)
+	binary.BigEndian.PutUint32(badBox, 1<<20)
+
+	tests := []struct {
+		name string
+		data []byte
+		want *Exif
+		// err is the error if it is a specific one, otherwise any error is
+		// expected when want is nil
+		err error
+	}{
+		{name: "tiff little endian", data: tiffII, want: full},
+		{name: "tiff big endian", data: tiffMM, want: full},
+		{
+			name: "tiff without exif ifd",
+			data: buildTIFF(binary.BigEndian, allTags, nil),
+			want: &Exif{DateTime: modified},
+		},
+		{
+			name: "tiff without offset",
+			data: buildTIFF(binary.LittleEndian, nil, []tag{{tagDateTimeOriginal, original}}),
+			want: &Exif{DateTimeOriginal: original},
+		},
+		{
+			name: "tiff without dates",
+			data: buildTIFF(binary.LittleEndian, []tag{{0x010F, "Camera"}}, nil),
+			err:  ErrNoExif,
+		},
+		{name: "jpeg", data: buildJPEG(tiffII), want: full},
+		{name: "jpeg big endian", data: buildJPEG(tiffMM), want: full},
+		{name: "jpeg without exif", data: buildJPEG(nil), err: ErrNoExif},
+		{name: "heic", data: buildHEIC(tiffMM), want: full},
+		{name: "heic little endian", data: buildHEIC(tiffII), want: full},
+		{name: "heic without exif", data: buildHEIC(nil), err: ErrNoExif},
+		{name: "empty", data: nil, err: ErrNoExif},
+		{name: "png", data: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), err: ErrNoExif},
+		{name: "truncated tiff", data: tiffII[:20], err: ErrNoExif},
+		{name: "truncated jpeg", data: buildJPEG(tiffMM)[:40], err: ErrNoExif},
+		{name: "truncated heic", data: buildHEIC(tiffMM)[:60]},
+		{name: "tiff with too many entries", data: manyEntries, err: ErrNoExif},
+		{name: "tiff with ifd past the end", data: farIFD, err: ErrNoExif},
+		{name: "heic with box past the end", data: badBox},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("Read() = %+v, want error", got)
+				}
+				if tt.err != nil && !errors.Is(err, tt.err) {
+					t.Fatalf("Read() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if *got != *tt.want {
+				t.Fatalf("Read() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	tiff := buildTIFF(binary.LittleEndian, allTags, exifAll)
+	files := map[string][]byte{
+		"tiff": tiff,
+		"jpeg": buildJPEG(tiff),
+		"heic": buildHEIC(tiff),
+	}
+
+	// Reading a prefix of a file never panics, and a tag it reads is never
+	// cut short
+	for name, data := range files {
+		for n := 0; n < len(data); n++ {
+			got, err := Read(bytes.NewReader(data[:n]), int64(n))
+			if err != nil {
+				continue
+			}
+			if (got.DateTimeOriginal != "" && got.DateTimeOriginal != original) ||
+				(got.OffsetTimeOriginal != "" && got.OffsetTimeOriginal != offset) ||
+				(got.DateTime != "" && got.DateTime != modified) {
+				t.Errorf("%s cut at %d: Read() = %+v", name, n, got)
+			}
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	loc := time.FixedZone("test", -3*60*60)
+
+	tests := []struct {
+		name string
+		exif Exif
+		want time.Time
+		err  bool
+	}{
+		{
+			name: "original with offset",
+			exif: Exif{DateTimeOriginal: original, OffsetTimeOriginal: offset, DateTime: modified},
+			want: time.Date(2019, 5, 3, 5, 20, 30, 0, time.UTC),
+		},
+		{
+			name: "original without offset",
+			exif: Exif{DateTimeOriginal: original, DateTime: modified},
+			want: time.Date(2019, 5, 3, 10, 20, 30, 0, loc),
+		},
+		{
+			name: "invalid offset",
+			exif: Exif{DateTimeOriginal: original, OffsetTimeOriginal: "   :  "},
+			want: time.Date(2019, 5, 3, 10, 20, 30, 0, loc),
+		},
+		{
+			name: "date time only",
+			exif: Exif{DateTime: modified, OffsetTimeOriginal: offset},
+			want: time.Date(2020, 1, 2, 3, 4, 5, 0, loc),
+		},
+		{
+			name: "unset date",
+			exif: Exif{DateTimeOriginal: "    :  :     :  :  "},
+			err:  true,
+		},
+		{
+			name: "no date",
+			exif: Exif{},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.exif.Time(loc)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("Time() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Time() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("Time() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package filtergraph builds ffmpeg -filter_complex descriptions.
+//
+// A Graph is a list of chains. Every chain reads from input pads, runs its
+// filters one after another and writes to output pads. Pads are checked
+// before the graph is rendered, so a typo in a label is reported instead of
+// being passed on to ffmpeg.
+package filtergraph
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Pad is a link between filters. It is rendered as [name].
+//
+// A pad whose name starts with a digit is a stream of an input file, like
+// 0:v or 1:a:0. Such a pad can be read any number of times. Every other
+// pad must be written by exactly one chain and read by exactly one chain,
+// unless it is an output of the graph.
+type Pad string
+
+// IsStream checks if pad is a stream of an input file
+func (p Pad) IsStream() bool {
+	return len(p) > 0 && p[0] >= '0' && p[0] <= '9'
+}
+
+func (p Pad) String() string {
+	return "[" + string(p) + "]"
+}
+
+var (
+	labelPattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	streamPattern = regexp.MustCompile(`^[0-9]+(:[A-Za-z0-9_]+)*$`)
+)
+
+func (p Pad) valid() bool {
+	if p.IsStream() {
+		return streamPattern.MatchString(string(p))
+	}
+	return labelPattern.MatchString(string(p))
+}
+
+// Pads returns pads named prefix1, prefix2 ... prefixN
+func Pads(prefix string, n int) []Pad {
+	p := make([]Pad, n)
+	for i := range p {
+		p[i] = Pad(fmt.Sprintf("%s%d", prefix, i+1))
+	}
+	return p
+}
+
+type option struct {
+	key   string
+	value string
+}
+
+// Filter is a single ffmpeg filter with its options
+type Filter struct {
+	name    string
+	options []option
+}
+
+// NewFilter returns filter with name and no options
+func NewFilter(name string) Filter {
+	return Filter{name: name}
+}
+
+// Set returns copy of filter with key=value option appended. Value is
+// formatted with fmt and escaped when the filter is rendered.
+func (f Filter) Set(key string, value interface{}) Filter {
+	f.options = append(f.options[:len(f.options):len(f.options)], option{key, formatValue(value)})
+	return f
+}
+
+// Arg returns copy of filter with positional option appended
+func (f Filter) Arg(value interface{}) Filter {
+	return f.Set("", value)
+}
+
+func formatValue(v interface{}) string {
+	switch t := v.(type) {
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
+
+func (f Filter) String() string {
+	if len(f.options) == 0 {
+		return f.name
+	}
+
+	o := make([]string, len(f.options))
+	for i, e := range f.options {
+		if e.key == "" {
+			o[i] = Escape(e.value)
+		} else {
+			o[i] = e.key + "=" + Escape(e.value)
+		}
+	}
+	return f.name + "=" + strings.Join(o, ":")
+}
+
+// Escape escapes value of a filter option. ffmpeg reads option values
+// twice, once when it splits the graph into filters and once when it
+// splits a filter into options, so value is escaped for both.
+func Escape(value string) string {
+	return escape(escape(value, `\':`), `\'[],;`)
+}
+
+func escape(s string, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Chain is a list of filters connected one after another
+type Chain struct {
+	Inputs  []Pad
+	Filters []Filter
+	Outputs []Pad
+}
+
+func (c Chain) String() string {
+	var b strings.Builder
+	for _, p := range c.Inputs {
+		b.WriteString(p.String())
+	}
+
+	f := make([]string, len(c.Filters))
+	for i, e := range c.Filters {
+		f[i] = e.String()
+	}
+	b.WriteString(strings.Join(f, ","))
+
+	for _, p := range c.Outputs {
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// Graph is a filter graph made of chains
+type Graph struct {
+	chains  []Chain
+	outputs []Pad
+}
+
+// Chain appends a chain that reads in, runs filters and writes out
+func (g *Graph) Chain(in []Pad, filters []Filter, out []Pad) {
+	g.chains = append(g.chains, Chain{Inputs: in, Filters: filters, Outputs: out})
+}
+
+// Filter appends a chain of a single filter
+func (g *Graph) Filter(in []Pad, f Filter, out ...Pad) {
+	g.Chain(in, []Filter{f}, out)
+}
+
+// Split copies video in to outs
+func (g *Graph) Split(in Pad, outs ...Pad) {
+	g.Filter([]Pad{in}, NewFilter("split").Arg(len(outs)), outs...)
+}
+
+// ASplit copies audio in to outs
+func (g *Graph) ASplit(in Pad, outs ...Pad) {
+	g.Filter([]Pad{in}, NewFilter("asplit").Arg(len(outs)), outs...)
+}
+
+// Overlay puts overlay on top of main
+func (g *Graph) Overlay(main Pad, overlay Pad, out Pad) {
+	g.Filter([]Pad{main, overlay}, NewFilter("overlay"), out)
+}
+
+// Concat joins segments one after another. Every segment has v video pads
+// followed by a audio pads. Outputs are v video pads followed by a audio
+// pads.
+func (g *Graph) Concat(segments [][]Pad, v int, a int, outs ...Pad) {
+	var in []Pad
+	for _, s := range segments {
+		in = append(in, s...)
+	}
+
+	g.Filter(in, NewFilter("concat").Set("n", len(segments)).Set("v", v).Set("a", a), outs...)
+}
+
+// Output marks pads that are read with -map instead of by a chain
+func (g *Graph) Output(pads ...Pad) {
+	g.outputs = append(g.outputs, pads...)
+}
+
+// Validate checks that every pad has a valid name and that every label is
+// written once and read once. Stream pads may only be read.
+func (g *Graph) Validate() error {
+	if len(g.chains) == 0 {
+		return errors.New("filter graph is empty")
+	}
+
+	written := map[Pad]int{}
+	read := map[Pad]int{}
+
+	for i, c := range g.chains {
+		if len(c.Filters) == 0 {
+			return fmt.Errorf("chain %d has no filters", i+1)
+		}
+		for _, p := range c.Inputs {
+			if !p.valid() {
+				return fmt.Errorf("invalid pad %s", p)
+			}
+			read[p]++
+		}
+		for _, p := range c.Outputs {
+			if !p.valid() || p.IsStream() {
+				return fmt.Errorf("invalid output pad %s", p)
+			}
+			written[p]++
+		}
+	}
+	for _, p := range g.outputs {
+		if !p.valid() || p.IsStream() {
+			return fmt.Errorf("invalid output pad %s", p)
+		}
+		read[p]++
+	}
+
+	var errs []string
+	for p, n := range written {
+		if n > 1 {
+			errs = append(errs, fmt.Sprintf("%s is written %d times", p, n))
+		}
+		if read[p] == 0 {
+			errs = append(errs, fmt.Sprintf("%s is never read", p))
+		}
+	}
+	for p, n := range read {
+		if p.IsStream() {
+			continue
+		}
+		if written[p] == 0 {
+			errs = append(errs, fmt.Sprintf("%s is never written", p))
+		} else if n > 1 {
+			errs = append(errs, fmt.Sprintf("%s is read %d times", p, n))
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errors.New("invalid filter graph: " + strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Render validates the graph and renders it for -filter_complex
+func (g *Graph) Render() (string, error) {
+	if err := g.Validate(); err != nil {
+		return "", err
+	}
+
+	c := make([]string, len(g.chains))
+	for i, e := range g.chains {
+		c[i] = e.String()
+	}
+	return strings.Join(c, "; "), nil
+}
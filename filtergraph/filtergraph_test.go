@@ -0,0 +1,211 @@
+// Copyright © 2018 Talha Mansoor <talha131@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package filtergraph
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(g *Graph)
+		err   string
+	}{
+		{
+			name: "valid",
+			build: func(g *Graph) {
+				g.Split("0:v", "a", "b")
+				g.Filter([]Pad{"a", "b"}, NewFilter("hstack"), "out")
+				g.Output("out")
+			},
+		},
+		{
+			name: "stream read twice",
+			build: func(g *Graph) {
+				g.Filter([]Pad{"0:v"}, NewFilter("null"), "a")
+				g.Filter([]Pad{"0:v", "a"}, NewFilter("hstack"), "out")
+				g.Output("out")
+			},
+		},
+		{
+			name:  "empty",
+			build: func(g *Graph) {},
+			err:   "filter graph is empty",
+		},
+		{
+			name: "never written",
+			build: func(g *Graph) {
+				g.Filter([]Pad{"0:v", "missing"}, NewFilter("hstack"), "out")
+				g.Output("out")
+			},
+			err: "[missing] is never written",
+		},
+		{
+			name: "never read",
+			build: func(g *Graph) {
+				g.Split("0:v", "a", "b")
+				g.Filter([]Pad{"a"}, NewFilter("null"), "out")
+				g.Output("out")
+			},
+			err: "[b] is never read",
+		},
+		{
+			name: "written twice",
+			build: func(g *Graph) {
+				g.Filter([]Pad{"0:v"}, NewFilter("null"), "out")
+				g.Filter([]Pad{"1:v"}, NewFilter("null"), "out")
+				g.Output("out")
+			},
+			err: "[out] is written 2 times",
+		},
+		{
+			name: "read twice",
+			build: func(g *Graph) {
+				g.Filter([]Pad{"0:v"}, NewFilter("null"), "a")
+				g.Filter([]Pad{"a", "a"}, NewFilter("hstack"), "out")
+				g.Output("out")
+			},
+			err: "[a] is read 2 times",
+		},
+		{
+			name: "write to stream",
+			build: func(g *Graph) {
+				g.Filter([]Pad{"0:v"}, NewFilter("null"), "1:v")
+			},
+			err: "invalid output pad [1:v]",
+		},
+		{
+			name: "output stream",
+			build: func(g *Graph) {
+				g.Filter([]Pad{"0:v"}, NewFilter("null"), "out")
+				g.Output("out", "0:a")
+			},
+			err: "invalid output pad [0:a]",
+		},
+		{
+			name: "invalid label",
+			build: func(g *Graph) {
+				g.Filter([]Pad{"0:v"}, NewFilter("null"), "a b")
+				g.Output("a b")
+			},
+			err: "invalid output pad [a b]",
+		},
+		{
+			name: "chain without filters",
+			build: func(g *Graph) {
+				g.Chain([]Pad{"0:v"}, nil, []Pad{"out"})
+				g.Output("out")
+			},
+			err: "chain 1 has no filters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Graph{}
+			tt.build(g)
+
+			err := g.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`plain`, `plain`},
+		{`it's`, `it\\\'s`},
+		{`00:01`, `00\\:01`},
+		{`[a]`, `\[a\]`},
+		{`a,b`, `a\,b`},
+		{`a;b`, `a\;b`},
+		{`C:\x`, `C\\:\\\\x`},
+	}
+
+	for _, tt := range tests {
+		if got := Escape(tt.in); got != tt.want {
+			t.Errorf("Escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	tests := []struct {
+		f    Filter
+		want string
+	}{
+		{NewFilter("reverse"), "reverse"},
+		{NewFilter("split").Arg(3), "split=3"},
+		{NewFilter("trim").Set("start", 1.5).Set("end", 10.0), "trim=start=1.5:end=10"},
+		{NewFilter("drawtext").Set("text", "a:b"), `drawtext=text=a\\:b`},
+	}
+
+	for _, tt := range tests {
+		if got := tt.f.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	g := &Graph{}
+	v := Pads("v", 2)
+	a := Pads("a", 2)
+	g.Split("0:v", v...)
+	g.ASplit("0:a", a...)
+	g.Concat([][]Pad{{v[0], a[0]}, {v[1], a[1]}}, 1, 1, "output", "aoutput")
+	g.Output("output", "aoutput")
+
+	got, err := g.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[0:v]split=2[v1][v2]; [0:a]asplit=2[a1][a2]; " +
+		"[v1][a1][v2][a2]concat=n=2:v=1:a=1[output][aoutput]"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderInvalid(t *testing.T) {
+	g := &Graph{}
+	g.Split("0:v", "v1", "v2")
+	g.Filter([]Pad{"v1"}, NewFilter("null"), "output")
+	g.Output("output")
+
+	if s, err := g.Render(); err == nil {
+		t.Errorf("Render() = %q, want error", s)
+	}
+}